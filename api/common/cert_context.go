@@ -1,8 +1,12 @@
 package common
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 	corev1 "k8s.io/api/core/v1"
@@ -21,8 +25,11 @@ type CertContext struct {
 	Key []byte
 
 	SigningKey []byte
-	// ca.crt
+	// the CA which signed Cert
 	SigningCert []byte
+	// ca.crt, the CAs clients should trust: SigningCert followed by a
+	// previous CA while certificates signed by it may still be served
+	CABundle []byte
 }
 
 func (c *CertContext) WriteCertFileToLocal(certDir string) error {
@@ -33,30 +40,80 @@ func (c *CertContext) WriteCertFileToLocal(certDir string) error {
 
 	// ca.crt
 	caCertFile := filepath.Join(certDir, "ca.crt")
-	if err := os.WriteFile(caCertFile, c.SigningCert, 0o644); err != nil {
+	if err := writeFileAtomic(caCertFile, c.CABundle, 0o644); err != nil {
 		return errors.Wrapf(err, "Failed to write file:%s", caCertFile)
 	}
 	// server.key
 	keyFile := filepath.Join(certDir, "tls.key")
-	if err := os.WriteFile(keyFile, c.Key, 0o644); err != nil {
+	if err := writeFileAtomic(keyFile, c.Key, 0o600); err != nil {
 		return errors.Wrapf(err, "Failed to write file:%s", keyFile)
 	}
 	// server.csr
 	certFile := filepath.Join(certDir, "tls.crt")
-	if err := os.WriteFile(certFile, c.Cert, 0o600); err != nil {
+	if err := writeFileAtomic(certFile, c.Cert, 0o644); err != nil {
 		return errors.Wrapf(err, "Failed to write file:%s", certFile)
 	}
 
 	return nil
 }
 
+// ReadCertFileFromLocal reads a certificate from the files WriteCertFileToLocal
+// writes, as mounted from a secret issued by cert-manager
+func ReadCertFileFromLocal(certDir string) (*CertContext, error) {
+	data := map[string][]byte{}
+	for _, name := range []string{cacrt, tlskey, tlscrt} {
+		v, err := os.ReadFile(filepath.Join(certDir, name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to read file:%s", name)
+		}
+		data[name] = v
+	}
+
+	return GenerateCertBySecret(&corev1.Secret{Data: data})
+}
+
+// writeFileAtomic replaces a file by renaming a fully written temporary file
+// over it, readers never see a partially written file
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+// ComposeSecrets returns the kubernetes.io/tls secret of the serving certificate,
+// the CA key is kept out of it, see ComposeCASecret
 func (c *CertContext) ComposeSecrets(namespace, name string) *corev1.Secret {
 	s := &corev1.Secret{}
 	s.Name = name
 	s.Namespace = namespace
-	s.Type = corev1.SecretTypeOpaque
+	s.Type = corev1.SecretTypeTLS
+	s.Labels = map[string]string{
+		LabelManagedBy: ManagedBy,
+		LabelComponent: ComponentServingCert,
+	}
+	s.Annotations = expiryAnnotations(c.Cert, c.SigningCert)
 	s.Data = map[string][]byte{
-		cacrt:  c.SigningCert,
+		cacrt:  c.CABundle,
 		tlskey: c.Key,
 		tlscrt: c.Cert,
 	}
@@ -64,10 +121,51 @@ func (c *CertContext) ComposeSecrets(namespace, name string) *corev1.Secret {
 	return s
 }
 
+// ComposeCASecret returns the kubernetes.io/tls secret of the signing CA and
+// its key, only the replica rotating the certificate reads it
+func (c *CertContext) ComposeCASecret(namespace, name string) *corev1.Secret {
+	s := &corev1.Secret{}
+	s.Name = name
+	s.Namespace = namespace
+	s.Type = corev1.SecretTypeTLS
+	s.Labels = map[string]string{
+		LabelManagedBy: ManagedBy,
+		LabelComponent: ComponentCA,
+	}
+	s.Annotations = expiryAnnotations(nil, c.SigningCert)
+	s.Data = map[string][]byte{
+		tlskey: c.SigningKey,
+		tlscrt: c.SigningCert,
+	}
+
+	return s
+}
+
+// expiryAnnotations tells when the certificate and the CA expire, a certificate
+// failing to parse is left out
+func expiryAnnotations(certPEM, caPEM []byte) map[string]string {
+	annotations := map[string]string{}
+	for k, v := range map[string][]byte{CertNotAfterAnnotation: certPEM, CANotAfterAnnotation: caPEM} {
+		if cert, err := parseFirstCert(v); err == nil {
+			annotations[k] = cert.NotAfter.UTC().Format(time.RFC3339)
+		}
+	}
+	return annotations
+}
+
+// GenerateCertBySecret parses the certificate of a secret, as composed by
+// ComposeSecrets or issued by cert-manager, and checks the key pairs with the
+// certificate and the certificate chains to the CA bundle
 func GenerateCertBySecret(s *corev1.Secret) (*CertContext, error) {
 	c := &CertContext{}
 	if v, ok := s.Data[cacrt]; ok {
-		c.SigningCert = v
+		// the signing CA comes first in the bundle
+		c.CABundle = v
+		block, _ := pem.Decode(v)
+		if block == nil {
+			return nil, errors.Errorf("%s holds no PEM certificate", cacrt)
+		}
+		c.SigningCert = pem.EncodeToMemory(block)
 	} else {
 		return nil, errors.Errorf("%s not found", cacrt)
 	}
@@ -83,5 +181,77 @@ func GenerateCertBySecret(s *corev1.Secret) (*CertContext, error) {
 	} else {
 		return nil, errors.Errorf("%s not found", tlscrt)
 	}
+
+	if err := c.Verify(); err != nil {
+		return nil, err
+	}
 	return c, nil
 }
+
+// Verify checks Key pairs with Cert and Cert chains to CABundle. The chain is
+// checked when the certificate was issued, an expired certificate is left to
+// rotation.
+func (c *CertContext) Verify() error {
+	pair, err := tls.X509KeyPair(c.Cert, c.Key)
+	if err != nil {
+		return errors.Wrapf(err, "%s doesn't pair with %s", tlskey, tlscrt)
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse %s", tlscrt)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(c.CABundle) {
+		return errors.Errorf("%s holds no valid certificate", cacrt)
+	}
+	// the rest of tls.crt may hold intermediate CAs
+	intermediates := x509.NewCertPool()
+	for _, der := range pair.Certificate[1:] {
+		if cert, err := x509.ParseCertificate(der); err == nil {
+			intermediates.AddCert(cert)
+		}
+	}
+
+	if _, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   leaf.NotBefore,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}); err != nil {
+		return errors.Wrapf(err, "%s doesn't chain to %s", tlscrt, cacrt)
+	}
+
+	return nil
+}
+
+// SetSigningKeyBySecret takes the CA key from a secret composed by ComposeCASecret,
+// the secret must hold the signing CA of c and a key pairing with it
+func (c *CertContext) SetSigningKeyBySecret(s *corev1.Secret) error {
+	if _, err := tls.X509KeyPair(s.Data[tlscrt], s.Data[tlskey]); err != nil {
+		return errors.Wrap(err, "CA key doesn't pair with the CA")
+	}
+
+	ca, err := parseFirstCert(s.Data[tlscrt])
+	if err != nil {
+		return errors.Wrap(err, "failed to parse CA")
+	}
+	signing, err := parseFirstCert(c.SigningCert)
+	if err != nil {
+		return errors.Wrap(err, "failed to parse signing CA")
+	}
+	if !ca.Equal(signing) {
+		return errors.New("CA secret doesn't hold the signing CA")
+	}
+
+	c.SigningKey = s.Data[tlskey]
+	return nil
+}
+
+func parseFirstCert(certPEM []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, errors.New("no PEM certificate")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
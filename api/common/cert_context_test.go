@@ -0,0 +1,87 @@
+package common_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/allenhaozi/webhook/api/common"
+	webhookutils "github.com/allenhaozi/webhook/pkg/utils"
+)
+
+func TestCertSecrets(t *testing.T) {
+	c, err := webhookutils.GenerateCert("default", "webhook-service", webhookutils.CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := webhookutils.GenerateCert("default", "webhook-service", webhookutils.CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := c.ComposeSecrets("default", "webhook-service")
+	if secret.Type != corev1.SecretTypeTLS || secret.Labels[common.LabelComponent] != common.ComponentServingCert ||
+		secret.Annotations[common.CertNotAfterAnnotation] == "" || secret.Annotations[common.CANotAfterAnnotation] == "" {
+		t.Errorf("unexpected secret metadata %v %v %v", secret.Type, secret.Labels, secret.Annotations)
+	}
+	if _, ok := secret.Data["ca.key"]; ok {
+		t.Error("CA key stored with the certificate")
+	}
+
+	parsed, err := common.GenerateCertBySecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.SigningKey) != 0 {
+		t.Error("CA key parsed from the certificate secret")
+	}
+
+	caSecret := c.ComposeCASecret("default", "webhook-service-ca")
+	if err := parsed.SetSigningKeyBySecret(caSecret); err != nil {
+		t.Fatal(err)
+	}
+	if string(parsed.SigningKey) != string(c.SigningKey) {
+		t.Error("CA key not taken from the CA secret")
+	}
+	if err := parsed.SetSigningKeyBySecret(other.ComposeCASecret("default", "webhook-service-ca")); err == nil {
+		t.Error("CA secret of another CA accepted")
+	}
+
+	// a key of another certificate
+	mismatched := c.ComposeSecrets("default", "webhook-service")
+	mismatched.Data["tls.key"] = other.Key
+	if _, err := common.GenerateCertBySecret(mismatched); err == nil {
+		t.Error("mismatched key accepted")
+	}
+
+	// a certificate of another CA
+	unchained := c.ComposeSecrets("default", "webhook-service")
+	unchained.Data["ca.crt"] = other.CABundle
+	if _, err := common.GenerateCertBySecret(unchained); err == nil {
+		t.Error("certificate of another CA accepted")
+	}
+}
+
+func TestWriteCertFileToLocal(t *testing.T) {
+	c, err := webhookutils.GenerateCert("default", "webhook-service", webhookutils.CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	certDir := t.TempDir()
+	if err := c.WriteCertFileToLocal(certDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the key is kept from other users
+	for name, perm := range map[string]os.FileMode{"ca.crt": 0o644, "tls.crt": 0o644, "tls.key": 0o600} {
+		info, err := os.Stat(filepath.Join(certDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != perm {
+			t.Errorf("expected %s to be written %o, got %o", name, perm, info.Mode().Perm())
+		}
+	}
+}
@@ -3,4 +3,42 @@ package common
 const (
 	WebHookName    = "webhook-service"
 	MyPodNamespace = "MY_POD_NAMESPACE"
+	// InjectCAAnnotation on a webhook configuration names the certificate secret,
+	// as "<namespace>/<name>" or "<name>", whose CA bundle is injected into it
+	InjectCAAnnotation = "webhook.allenhaozi.io/inject-ca"
+	// CatalogToken is the environment variable holding the metadata catalog bearer token
+	CatalogToken = "CATALOG_TOKEN"
+
+	// ValuesAnnotation carries inline YAML values used to render workflow manifests
+	ValuesAnnotation = "webhook.allenhaozi.io/values"
+	// ValuesFromAnnotation names a ConfigMap in the workflow namespace holding rendering values
+	ValuesFromAnnotation = "webhook.allenhaozi.io/values-from"
+	// ValuesConfigMapKey is the ConfigMap key read for ValuesFromAnnotation
+	ValuesConfigMapKey = "values.yaml"
+
+	// ChartAnnotation on a resource template names a chart, relative to the chart
+	// directory, whose rendered resource replaces the template manifest
+	ChartAnnotation = "webhook.allenhaozi.io/chart"
+	// ChartValuesAnnotation on a resource template carries YAML values merged over the workflow values
+	ChartValuesAnnotation = "webhook.allenhaozi.io/chart-values"
+	// ChartKindAnnotation on a resource template selects the kind of the rendered resource
+	ChartKindAnnotation = "webhook.allenhaozi.io/chart-kind"
+	DefaultChartKind    = "SparkApplication"
+	// TemplateEngineAnnotation on a resource template selects the engine rendering
+	// its manifest: go (the default, when empty), sprig or jinja2. Only the
+	// manifests of the templates carrying it are rendered.
+	TemplateEngineAnnotation = "webhook.allenhaozi.io/template-engine"
+
+	// LabelManagedBy and LabelComponent label the certificate secrets
+	LabelManagedBy       = "app.kubernetes.io/managed-by"
+	LabelComponent       = "app.kubernetes.io/component"
+	ManagedBy            = "webhook"
+	ComponentServingCert = "serving-cert"
+	ComponentCA          = "ca"
+	// CASecretSuffix names the secret holding the CA key after the certificate secret
+	CASecretSuffix = "-ca"
+	// CertNotAfterAnnotation and CANotAfterAnnotation on the certificate secrets
+	// tell, in RFC 3339, when the certificate and the CA expire
+	CertNotAfterAnnotation = "webhook.allenhaozi.io/cert-not-after"
+	CANotAfterAnnotation   = "webhook.allenhaozi.io/ca-not-after"
 )
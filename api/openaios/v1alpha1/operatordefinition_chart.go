@@ -0,0 +1,35 @@
+package v1alpha1
+
+import (
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// LabelName names the chart of an OperatorDefinition without spec.chart
+const LabelName = "app.kubernetes.io/name"
+
+// ChartName returns the chart of the operator, spec.chart or else the
+// LabelName label, empty when neither is set
+func (r *OperatorDefinition) ChartName() string {
+	if r.Spec.Chart != "" {
+		return r.Spec.Chart
+	}
+	return r.Labels[LabelName]
+}
+
+// ChartPath returns the path of chart in chartDir, charts are confined to chartDir
+func ChartPath(chartDir, chart string) string {
+	return filepath.Join(chartDir, filepath.Clean("/"+chart))
+}
+
+// ChartValues returns the OperatorDefinition as the values of its chart, without its status
+func (r *OperatorDefinition) ChartValues() (map[string]interface{}, error) {
+	values, err := runtime.DefaultUnstructuredConverter.ToUnstructured(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "fail to convert OperatorDefinition to values")
+	}
+	delete(values, "status")
+	return values, nil
+}
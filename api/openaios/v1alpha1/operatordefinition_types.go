@@ -0,0 +1,237 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// OperatorDefinitionSpec defines the inputs, outputs and params of an operator run
+type OperatorDefinitionSpec struct {
+	// Chart is the chart rendering the SparkApplication of the operator,
+	// relative to the chart directory. It defaults to the app.kubernetes.io/name label.
+	// +optional
+	Chart string `json:"chart,omitempty"`
+	// Inputs are the resources the operator reads
+	// +optional
+	Inputs []OperatorResource `json:"inputs,omitempty"`
+	// Outputs are the resources the operator writes
+	// +optional
+	Outputs []OperatorResource `json:"outputs,omitempty"`
+	// Params are passed as is to the operator
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Params *apiextensionsv1.JSON `json:"params,omitempty"`
+}
+
+// OperatorResource is an input or an output of an operator
+type OperatorResource struct {
+	Name string `json:"name"`
+	// +optional
+	Description string `json:"description,omitempty"`
+	// ResourceType is the type of the resource, e.g. table or model
+	ResourceType string `json:"resourceType"`
+	// TableType is the format of a table, e.g. iceberg or csv
+	// +optional
+	TableType string `json:"tableType,omitempty"`
+	// +optional
+	KedroDatasetType string `json:"kedroDatasetType,omitempty"`
+	// Conditions filter the data read from the resource
+	// +optional
+	Conditions []ResourceCondition `json:"conditions,omitempty"`
+	// +optional
+	Meta *ResourceMeta `json:"meta,omitempty"`
+}
+
+// ResourceCondition is a named filter of a resource, e.g. date = 2022-11-23
+type ResourceCondition struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+}
+
+// ResourceMeta is the catalog metadata of a resource
+type ResourceMeta struct {
+	// ID of the resource in the catalog
+	// +optional
+	ID string `json:"id,omitempty"`
+	// +optional
+	Name string `json:"name,omitempty"`
+	// Path and Warehouse locate the data of the resource
+	// +optional
+	Path string `json:"path,omitempty"`
+	// +optional
+	Warehouse string `json:"warehouse,omitempty"`
+	// +optional
+	Columns []ResourceColumn `json:"columns,omitempty"`
+}
+
+// ResourceColumn is a column of a table resource
+type ResourceColumn struct {
+	Name     string `json:"name"`
+	DataType string `json:"dataType,omitempty"`
+}
+
+// Credentials are the storage credentials of an operator
+type Credentials struct {
+	// +optional
+	S3 []S3Credential `json:"s3,omitempty"`
+	// +optional
+	HDFS []HDFSCredential `json:"hdfs,omitempty"`
+}
+
+// S3Credential gives access to an S3 compatible storage
+type S3Credential struct {
+	Name string `json:"name"`
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// Deprecated: AccessKey is rejected, the access key may not be given in plain text, see AccessKeyRef
+	// +optional
+	AccessKey string `json:"accessKey,omitempty"`
+	// Deprecated: SecretKey is rejected, the secret key may not be given in plain text, see SecretKeyRef
+	// +optional
+	SecretKey string `json:"secretKey,omitempty"`
+	// AccessKeyRef selects the access key in a Secret
+	// +optional
+	AccessKeyRef *corev1.SecretKeySelector `json:"accessKeyRef,omitempty"`
+	// SecretKeyRef selects the secret key in a Secret
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// HDFSCredential gives access to a HDFS cluster, kerberized or not
+type HDFSCredential struct {
+	Name string `json:"name"`
+	// HadoopConfigurationDir is where the hadoop configuration files are mounted
+	// +optional
+	HadoopConfigurationDir string `json:"hadoopConfigurationDir,omitempty"`
+	// KerberosConfigurationDir is where the kerberos files are mounted
+	// +optional
+	KerberosConfigurationDir string `json:"kerberosConfigurationDir,omitempty"`
+	// +optional
+	HadoopUserName string `json:"hadoopUserName,omitempty"`
+	// Deprecated: HadoopConfiguration is rejected, the hadoop configuration files
+	// may not be given in plain text, see HadoopConfigurationSecret
+	// +optional
+	HadoopConfiguration map[string]string `json:"hadoopConfiguration,omitempty"`
+	// Deprecated: KerberosConfiguration is rejected, the keytab and krb5.conf may
+	// not be given in plain text, see KerberosSecret
+	// +optional
+	KerberosConfiguration map[string]string `json:"kerberosConfiguration,omitempty"`
+	// HadoopConfigurationSecret names a Secret holding the hadoop configuration
+	// files, mounted at HadoopConfigurationDir
+	// +optional
+	HadoopConfigurationSecret string `json:"hadoopConfigurationSecret,omitempty"`
+	// KerberosSecret names a Secret holding the keytab and krb5.conf, mounted at
+	// KerberosConfigurationDir
+	// +optional
+	KerberosSecret string `json:"kerberosSecret,omitempty"`
+	// KerberosPrincipal is the principal of the keytab of KerberosSecret
+	// +optional
+	KerberosPrincipal string `json:"kerberosPrincipal,omitempty"`
+}
+
+// RuntimeConfig configures the SparkApplication running the operator
+type RuntimeConfig struct {
+	// +optional
+	Image *Image `json:"image,omitempty"`
+	// Driver is the driver spec of the SparkApplication
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Driver *apiextensionsv1.JSON `json:"driver,omitempty"`
+	// Executor is the executor spec of the SparkApplication
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Executor *apiextensionsv1.JSON `json:"executor,omitempty"`
+}
+
+// Image is the container image of the operator
+type Image struct {
+	Repository string `json:"repository"`
+	// +optional
+	Tag string `json:"tag,omitempty"`
+}
+
+// condition types of OperatorDefinitionStatus
+const (
+	// ConditionRendered tells whether the chart rendered the SparkApplication of the generation
+	ConditionRendered = "Rendered"
+	// ConditionSucceeded tells whether the SparkApplication completed, it is
+	// unknown while the application runs
+	ConditionSucceeded = "Succeeded"
+)
+
+// OperatorDefinitionStatus mirrors the state of the SparkApplication running the operator
+type OperatorDefinitionStatus struct {
+	// ObservedGeneration is the generation the SparkApplication was rendered for
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// SparkApplication is the name of the SparkApplication of ObservedGeneration
+	// +optional
+	SparkApplication string `json:"sparkApplication,omitempty"`
+	// ApplicationState is the state of the SparkApplication, e.g. RUNNING or COMPLETED
+	// +optional
+	ApplicationState string `json:"applicationState,omitempty"`
+	// Message is the error message of the SparkApplication
+	// +optional
+	Message string `json:"message,omitempty"`
+	// Conditions are Rendered and Succeeded
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:shortName=opdef
+//+kubebuilder:printcolumn:name="Application",type=string,JSONPath=`.status.sparkApplication`
+//+kubebuilder:printcolumn:name="State",type=string,JSONPath=`.status.applicationState`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// OperatorDefinition is a run of an operator, the chart of the operator renders
+// it into a SparkApplication. Like the values of the chart, credentials,
+// sparkConfiguration and runtimeConfig sit next to spec.
+type OperatorDefinition struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec OperatorDefinitionSpec `json:"spec,omitempty"`
+	// +optional
+	Credentials *Credentials `json:"credentials,omitempty"`
+	// SparkConfiguration is the sparkConf of the SparkApplication
+	// +optional
+	SparkConfiguration map[string]string `json:"sparkConfiguration,omitempty"`
+	// +optional
+	RuntimeConfig *RuntimeConfig `json:"runtimeConfig,omitempty"`
+
+	Status OperatorDefinitionStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// OperatorDefinitionList contains a list of OperatorDefinition
+type OperatorDefinitionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []OperatorDefinition `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&OperatorDefinition{}, &OperatorDefinitionList{})
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xeipuuv/gojsonschema"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/allenhaozi/webhook/pkg/catalog"
+)
+
+// ResourceTypeTable is the type of the resources held by the catalog
+const ResourceTypeTable = "table"
+
+// log is for logging in this package.
+var operatordefinitionlog = logf.Log.WithName("operatordefinition-resource")
+
+// SetupWebhookWithManager registers the OperatorDefinition webhooks, tables are resolved
+// through catalogClient, which may be nil to skip every catalog lookup, and definitions
+// are validated against the values.schema.json of their chart in chartDir
+func (r *OperatorDefinition) SetupWebhookWithManager(mgr ctrl.Manager, catalogClient catalog.Client, chartDir string) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&operatorDefinitionDefaulter{catalog: catalogClient}).
+		WithValidator(&operatorDefinitionValidator{chartDir: chartDir}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-openaios-4pd-io-v1alpha1-operatordefinition,mutating=true,failurePolicy=fail,sideEffects=None,groups=openaios.4pd.io,resources=operatordefinitions,verbs=create;update,versions=v1alpha1,name=moperatordefinition.kb.io,admissionReviewVersions=v1
+
+// operatorDefinitionDefaulter fills the metadata of the table inputs and outputs in from the catalog
+type operatorDefinitionDefaulter struct {
+	catalog catalog.Client
+}
+
+var _ admission.CustomDefaulter = &operatorDefinitionDefaulter{}
+
+// Default sets the name, path, warehouse and columns of every table input and
+// output with a meta.id to those the catalog holds for that id. Tables without
+// an id keep the metadata filled in by hand, e.g. when developing locally.
+func (d *operatorDefinitionDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*OperatorDefinition)
+	if !ok {
+		return fmt.Errorf("expected an OperatorDefinition but got a %T", obj)
+	}
+	operatordefinitionlog.Info("default", "name", r.Name)
+
+	if d.catalog == nil {
+		return nil
+	}
+
+	specPath := field.NewPath("spec")
+	allErrs := field.ErrorList{}
+	allErrs = append(allErrs, d.resolveTables(ctx, specPath.Child("inputs"), r.Spec.Inputs)...)
+	allErrs = append(allErrs, d.resolveTables(ctx, specPath.Child("outputs"), r.Spec.Outputs)...)
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "OperatorDefinition"}, r.Name, allErrs)
+}
+
+func (d *operatorDefinitionDefaulter) resolveTables(ctx context.Context, path *field.Path, resources []OperatorResource) field.ErrorList {
+	allErrs := field.ErrorList{}
+	for i := range resources {
+		res := &resources[i]
+		if res.ResourceType != ResourceTypeTable || res.Meta == nil || res.Meta.ID == "" {
+			continue
+		}
+		idPath := path.Index(i).Child("meta", "id")
+
+		table, err := d.catalog.GetTableByID(ctx, res.Meta.ID)
+		switch {
+		case catalog.IsNotFound(err):
+			allErrs = append(allErrs, field.NotFound(idPath, res.Meta.ID))
+			continue
+		case err != nil:
+			allErrs = append(allErrs, field.InternalError(idPath, err))
+			continue
+		}
+
+		res.Meta.Name = table.FullyQualifiedName
+		res.Meta.Path = table.Path
+		res.Meta.Warehouse = table.Warehouse
+		res.Meta.Columns = nil
+		for _, col := range table.Columns {
+			res.Meta.Columns = append(res.Meta.Columns, ResourceColumn{Name: col.Name, DataType: col.DataType})
+		}
+	}
+	return allErrs
+}
+
+//+kubebuilder:webhook:path=/validate-openaios-4pd-io-v1alpha1-operatordefinition,mutating=false,failurePolicy=fail,sideEffects=None,groups=openaios.4pd.io,resources=operatordefinitions,verbs=create;update,versions=v1alpha1,name=voperatordefinition.kb.io,admissionReviewVersions=v1
+
+// ValuesSchemaFile is the JSON schema a chart ships for its values, as helm names it
+const ValuesSchemaFile = "values.schema.json"
+
+// operatorDefinitionValidator validates the credentials of the definitions and
+// their values against the values schema of their chart
+type operatorDefinitionValidator struct {
+	chartDir string
+}
+
+var _ admission.CustomValidator = &operatorDefinitionValidator{}
+
+// ValidateCreate implements admission.CustomValidator
+func (v *operatorDefinitionValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	return v.validate(obj)
+}
+
+// ValidateUpdate implements admission.CustomValidator
+func (v *operatorDefinitionValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	return v.validate(newObj)
+}
+
+// ValidateDelete implements admission.CustomValidator
+func (v *operatorDefinitionValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	return nil
+}
+
+// validate checks that no credential is given in plain text, then the chart values of the definition against the
+// values schema of its chart, and reports every violation with its field path
+func (v *operatorDefinitionValidator) validate(obj runtime.Object) error {
+	r, ok := obj.(*OperatorDefinition)
+	if !ok {
+		return fmt.Errorf("expected an OperatorDefinition but got a %T", obj)
+	}
+	operatordefinitionlog.Info("validate", "name", r.Name)
+
+	allErrs := ValidateCredentials(field.NewPath("credentials"), r.Credentials)
+	schemaErrs, err := v.validateSchema(r)
+	if err != nil {
+		return err
+	}
+	allErrs = append(allErrs, schemaErrs...)
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "OperatorDefinition"}, r.Name, allErrs)
+}
+
+// ValidateCredentials rejects the credentials given in plain text, secrets
+// are only read from the Secrets the credentials reference
+func ValidateCredentials(path *field.Path, creds *Credentials) field.ErrorList {
+	allErrs := field.ErrorList{}
+	if creds == nil {
+		return allErrs
+	}
+	for i, s3 := range creds.S3 {
+		if s3.AccessKey != "" {
+			allErrs = append(allErrs, field.Forbidden(path.Child("s3").Index(i).Child("accessKey"), "may not be given in plain text, use accessKeyRef"))
+		}
+		if s3.SecretKey != "" {
+			allErrs = append(allErrs, field.Forbidden(path.Child("s3").Index(i).Child("secretKey"), "may not be given in plain text, use secretKeyRef"))
+		}
+	}
+	for i, hdfs := range creds.HDFS {
+		if len(hdfs.HadoopConfiguration) > 0 {
+			allErrs = append(allErrs, field.Forbidden(path.Child("hdfs").Index(i).Child("hadoopConfiguration"), "may not be given in plain text, use hadoopConfigurationSecret"))
+		}
+		if len(hdfs.KerberosConfiguration) > 0 {
+			allErrs = append(allErrs, field.Forbidden(path.Child("hdfs").Index(i).Child("kerberosConfiguration"), "may not be given in plain text, use kerberosSecret"))
+		}
+	}
+	return allErrs
+}
+
+// validateSchema checks the chart values of the definition against the values
+// schema of its chart, definitions whose chart ships no schema are not checked.
+// The values are merged over the values.yaml defaults of the chart first, as
+// chartutil.ToRenderValues does before helm validates them: a value the chart
+// defaults may be left out of the definition.
+func (v *operatorDefinitionValidator) validateSchema(r *OperatorDefinition) (field.ErrorList, error) {
+	chart := r.ChartName()
+	if v.chartDir == "" || chart == "" {
+		return nil, nil
+	}
+	chartPath := ChartPath(v.chartDir, chart)
+	schemaJSON, err := os.ReadFile(filepath.Join(chartPath, ValuesSchemaFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := r.ChartValues()
+	if err != nil {
+		return nil, err
+	}
+	ch, err := loader.Load(chartPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to load chart %s", chart)
+	}
+	merged, err := chartutil.CoalesceValues(ch, values)
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to merge the values.yaml defaults of chart %s", chart)
+	}
+	result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(schemaJSON), gojsonschema.NewGoLoader(merged.AsMap()))
+	if err != nil {
+		return nil, errors.Wrapf(err, "fail to validate against %s of chart %s", ValuesSchemaFile, chart)
+	}
+
+	allErrs := field.ErrorList{}
+	for _, e := range result.Errors() {
+		allErrs = append(allErrs, schemaFieldError(e))
+	}
+	return allErrs, nil
+}
+
+// schemaFieldError converts a JSON schema violation into a field error, array
+// indexes of the violation context become path indexes
+func schemaFieldError(e gojsonschema.ResultError) *field.Error {
+	var path *field.Path
+	// the first element of the context is the (root) of the values
+	for _, elem := range strings.Split(e.Context().String("\x00"), "\x00")[1:] {
+		switch i, err := strconv.Atoi(elem); {
+		case path == nil:
+			path = field.NewPath(elem)
+		case err == nil:
+			path = path.Index(i)
+		default:
+			path = path.Child(elem)
+		}
+	}
+
+	// the path leads the error, not the description
+	description := strings.TrimPrefix(e.Description(), e.Field()+" ")
+	property, _ := e.Details()["property"].(string)
+	child := func() *field.Path {
+		if path == nil {
+			return field.NewPath(property)
+		}
+		return path.Child(property)
+	}
+
+	switch e.Type() {
+	case "required":
+		return field.Required(child(), "")
+	case "additional_property_not_allowed":
+		return field.Forbidden(child(), description)
+	}
+	if path == nil {
+		path = field.NewPath("")
+	}
+	return field.Invalid(path, e.Value(), description)
+}
@@ -0,0 +1,126 @@
+package v1alpha1
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/allenhaozi/webhook/pkg/catalog"
+)
+
+func TestOperatorDefinitionDefault(t *testing.T) {
+	d := &operatorDefinitionDefaulter{catalog: catalog.NewFakeClient(catalog.Table{
+		ID:                 "5c3b5d0e",
+		Name:               "bo_sku",
+		FullyQualifiedName: "hcml_lite.default.bo_sku",
+		Path:               "/metaxis-6666/warehouse/hcml_lite/bo_sku",
+		Warehouse:          "/metaxis-6666/warehouse",
+		Columns:            []catalog.Column{{Name: "sku_id", DataType: "INT"}},
+	})}
+
+	r := &OperatorDefinition{Spec: OperatorDefinitionSpec{
+		Inputs: []OperatorResource{
+			{Name: "input-01", ResourceType: ResourceTypeTable, Meta: &ResourceMeta{ID: "5c3b5d0e", Name: "stale"}},
+			// without an id the metadata filled in by hand is kept
+			{Name: "input-02", ResourceType: ResourceTypeTable, Meta: &ResourceMeta{Name: "hcml_lite.sku_basic"}},
+		},
+		Outputs: []OperatorResource{
+			{Name: "output-sku-model", ResourceType: "model", Meta: &ResourceMeta{ID: "e2f701c2"}},
+		},
+	}}
+	if err := d.Default(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	in := r.Spec.Inputs[0].Meta
+	if in.Name != "hcml_lite.default.bo_sku" || in.Warehouse != "/metaxis-6666/warehouse" || len(in.Columns) != 1 || in.Columns[0].DataType != "INT" {
+		t.Errorf("unexpected input meta %+v", in)
+	}
+	if r.Spec.Inputs[1].Meta.Name != "hcml_lite.sku_basic" {
+		t.Errorf("expected the meta of a table without id to be kept, got %+v", r.Spec.Inputs[1].Meta)
+	}
+
+	r.Spec.Outputs = append(r.Spec.Outputs, OperatorResource{Name: "output-table", ResourceType: ResourceTypeTable, Meta: &ResourceMeta{ID: "missing"}})
+	err := d.Default(context.Background(), r)
+	if !apierrors.IsInvalid(err) || !strings.Contains(err.Error(), "spec.outputs[1].meta.id") {
+		t.Errorf("expected an unknown table id to be rejected with its field path, got %v", err)
+	}
+}
+
+func TestOperatorDefinitionValidate(t *testing.T) {
+	v := &operatorDefinitionValidator{chartDir: filepath.Join("..", "..", "..", "charts")}
+
+	r := &OperatorDefinition{
+		ObjectMeta: metav1.ObjectMeta{Name: "salesforecast", Labels: map[string]string{LabelName: "salesforecast"}},
+		Spec: OperatorDefinitionSpec{
+			Inputs: []OperatorResource{{
+				Name: "input-01", ResourceType: ResourceTypeTable, TableType: "iceberg",
+				Conditions: []ResourceCondition{{Name: "date", Value: "date = 2022-11-23"}},
+			}},
+			Outputs: []OperatorResource{{Name: "output-sku-model", ResourceType: "model"}},
+			Params:  &apiextensionsv1.JSON{Raw: []byte(`{"run_conf": {"data_date": "2022-08-01", "run_date": "2022-08-01", "target_freq": "W", "future_freq": "13", "model": "predict"}}`)},
+		},
+		RuntimeConfig: &RuntimeConfig{Image: &Image{Repository: "gcr.io/spark-operator/spark-py", Tag: "v3.1.1"}},
+	}
+	if err := v.ValidateCreate(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+
+	// the values.yaml defaults of the chart fill in the values left out
+	defaulted := r.DeepCopy()
+	defaulted.RuntimeConfig = nil
+	defaulted.Spec.Params.Raw = []byte(`{"run_conf": {"data_date": "2022-08-01", "run_date": "2022-08-01", "target_freq": "W", "future_freq": "13"}}`)
+	if err := v.ValidateCreate(context.Background(), defaulted); err != nil {
+		t.Errorf("expected the chart defaults to complete the definition, got %v", err)
+	}
+
+	r.Spec.Inputs[0].TableType = "orc"
+	r.Spec.Inputs[0].Conditions[0].Value = ""
+	r.Spec.Params.Raw = []byte(`{"run_conf": {"data_date": "2022-08-01", "run_date": "2022-08-01", "target_freq": "W", "future_freq": "13", "model": "forecast"}}`)
+	err := v.ValidateUpdate(context.Background(), nil, r)
+	if !apierrors.IsInvalid(err) {
+		t.Fatalf("expected the definition to be invalid, got %v", err)
+	}
+	for _, path := range []string{"spec.inputs[0].tableType", "spec.inputs[0].conditions[0].value", "spec.params.run_conf.model"} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("expected a violation at %s, got %v", path, err)
+		}
+	}
+
+	// secrets may not be given in plain text, with or without a reference
+	r.Credentials = &Credentials{
+		S3: []S3Credential{{
+			Name:         "s3-default",
+			AccessKey:    "plain",
+			SecretKey:    "plain",
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "minio"}, Key: "secretkey"},
+		}},
+		HDFS: []HDFSCredential{{
+			Name:                      "hdfs-default",
+			HadoopConfiguration:       map[string]string{"core-site.xml": "<configuration/>"},
+			HadoopConfigurationSecret: "hdfs-default-conf",
+			KerberosConfiguration:     map[string]string{"krb5.conf": "[libdefaults]"},
+		}},
+	}
+	err = v.ValidateCreate(context.Background(), r)
+	if !apierrors.IsInvalid(err) {
+		t.Fatalf("expected the plain text credentials to be rejected, got %v", err)
+	}
+	for _, path := range []string{"credentials.s3[0].accessKey", "credentials.s3[0].secretKey", "credentials.hdfs[0].hadoopConfiguration", "credentials.hdfs[0].kerberosConfiguration"} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("expected a violation at %s, got %v", path, err)
+		}
+	}
+	r.Credentials = nil
+
+	// charts without a schema leave the definitions unchecked
+	r.Spec.Chart = "missing"
+	if err := v.ValidateCreate(context.Background(), r); err != nil {
+		t.Errorf("expected no validation without a schema, got %v", err)
+	}
+}
@@ -27,22 +27,67 @@ import (
 type MetaWebHookSpec struct {
 	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
-	ServiceType    string `json:"serviceType,omitempty"`
+
+	// ServiceType is the type of the database service holding the table, e.g.
+	// Iceberg or Mysql, the table of the catalog must be of that type when set
+	ServiceType string `json:"serviceType,omitempty"`
+	// Service is the database service holding the table in the catalog, the
+	// first part of the service.database.schema.table TableFQN
+	Service        string `json:"service,omitempty"`
 	Database       string `json:"database,omitempty"`
 	DatabaseSchema string `json:"databaseSchema,omitempty"`
 	TableFQN       string `json:"tableFQN,omitempty"`
 	TableId        string `json:"tableId,omitempty"`
 }
 
+// condition types of MetaWebHookStatus
+const (
+	// ConditionCatalogResolved tells whether the table was found in the catalog
+	ConditionCatalogResolved = "CatalogResolved"
+	// ConditionReady tells whether the status holds the table metadata of the current
+	// spec, metadata synced before the catalog became unreachable is kept
+	ConditionReady = "Ready"
+)
+
 // MetaWebHookStatus defines the observed state of MetaWebHook
 type MetaWebHookStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
-	Status string `json:"status,omitempty"`
+	// ObservedGeneration is the generation of the spec last reconciled
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastSyncTime is when the table metadata was last synced from the catalog
+	// +optional
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// Table is the table metadata synced from the catalog
+	// +optional
+	Table *TableMetadata `json:"table,omitempty"`
+	// Conditions are CatalogResolved and Ready
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+}
+
+// TableMetadata is the catalog metadata of a table
+type TableMetadata struct {
+	Name               string `json:"name,omitempty"`
+	FullyQualifiedName string `json:"fullyQualifiedName,omitempty"`
+	// Path and Warehouse locate the data of lakehouse tables
+	Path      string        `json:"path,omitempty"`
+	Warehouse string        `json:"warehouse,omitempty"`
+	Columns   []TableColumn `json:"columns,omitempty"`
+}
+
+// TableColumn is a column of a catalog table
+type TableColumn struct {
+	Name     string `json:"name"`
+	DataType string `json:"dataType,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Table",type=string,JSONPath=`.spec.tableFQN`
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Last Sync",type=date,JSONPath=`.status.lastSyncTime`
 
 // MetaWebHook is the Schema for the metawebhooks API
 type MetaWebHook struct {
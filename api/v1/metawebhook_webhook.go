@@ -17,33 +17,181 @@ limitations under the License.
 package v1
 
 import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
-	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
-	"github.com/allenhaozi/alog"
+	"github.com/allenhaozi/webhook/pkg/catalog"
 )
 
 // log is for logging in this package.
 var metawebhooklog = logf.Log.WithName("metawebhook-resource")
 
-func (r *MetaWebHook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+// SetupWebhookWithManager registers the MetaWebHook webhooks, tables are resolved
+// through catalogClient, which may be nil to skip every catalog lookup
+func (r *MetaWebHook) SetupWebhookWithManager(mgr ctrl.Manager, catalogClient catalog.Client) error {
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
+		WithDefaulter(&metaWebHookDefaulter{catalog: catalogClient}).
+		WithValidator(&metaWebHookValidator{catalog: catalogClient}).
 		Complete()
 }
 
-// TODO(user): EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
-
 //+kubebuilder:webhook:path=/mutate-meta-github-com-v1-metawebhook,mutating=true,failurePolicy=fail,sideEffects=None,groups=meta.github.com,resources=metawebhooks,verbs=create;update,versions=v1,name=mmetawebhook.kb.io,admissionReviewVersions=v1
 
-var _ webhook.Defaulter = &MetaWebHook{}
+// metaWebHookDefaulter resolves the table a MetaWebHook refers to in the metadata catalog
+type metaWebHookDefaulter struct {
+	catalog catalog.Client
+}
+
+var _ admission.CustomDefaulter = &metaWebHookDefaulter{}
 
-// Default implements webhook.Defaulter so a webhook will be registered for the type
-func (r *MetaWebHook) Default() {
+// Default normalizes Spec.TableFQN to service.database.schema.table and sets Spec.TableId
+// to the id the catalog holds for that table
+func (d *metaWebHookDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*MetaWebHook)
+	if !ok {
+		return fmt.Errorf("expected a MetaWebHook but got a %T", obj)
+	}
 	metawebhooklog.Info("default", "name", r.Name)
 
-	alog.Pretty(r)
-	r.Spec.TableId = "456"
-	// TODO(user): fill in your defaulting logic.
+	r.Spec.TableFQN = r.Spec.normalizedTableFQN()
+
+	if d.catalog == nil || r.Spec.TableFQN == "" {
+		return nil
+	}
+
+	table, err := d.catalog.GetTableByFQN(ctx, r.Spec.TableFQN)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve table %s", r.Spec.TableFQN)
+	}
+	r.Spec.TableId = table.ID
+
+	return nil
+}
+
+// normalizedTableFQN qualifies a table, schema.table or database.schema.table
+// name with the service, database and schema of the spec
+func (s *MetaWebHookSpec) normalizedTableFQN() string {
+	if s.TableFQN == "" {
+		return ""
+	}
+	parts := strings.Split(s.TableFQN, ".")
+	switch len(parts) {
+	case 1:
+		return catalog.FQN(s.Service, s.Database, s.DatabaseSchema, parts[0])
+	case 2:
+		return catalog.FQN(s.Service, s.Database, parts[0], parts[1])
+	case 3:
+		return catalog.FQN(s.Service, parts[0], parts[1], parts[2])
+	default:
+		return s.TableFQN
+	}
+}
+
+//+kubebuilder:webhook:path=/validate-meta-github-com-v1-metawebhook,mutating=false,failurePolicy=fail,sideEffects=None,groups=meta.github.com,resources=metawebhooks,verbs=create;update,versions=v1,name=vmetawebhook.kb.io,admissionReviewVersions=v1
+
+// metaWebHookValidator checks a MetaWebHook against the metadata catalog
+type metaWebHookValidator struct {
+	catalog catalog.Client
+}
+
+var _ admission.CustomValidator = &metaWebHookValidator{}
+
+// ValidateCreate implements admission.CustomValidator
+func (v *metaWebHookValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*MetaWebHook)
+	if !ok {
+		return fmt.Errorf("expected a MetaWebHook but got a %T", obj)
+	}
+	metawebhooklog.Info("validate create", "name", r.Name)
+
+	return v.toInvalid(r, v.validateSpec(ctx, r))
+}
+
+// ValidateUpdate implements admission.CustomValidator, Spec.TableId can not change once set
+func (v *metaWebHookValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	old, ok := oldObj.(*MetaWebHook)
+	if !ok {
+		return fmt.Errorf("expected a MetaWebHook but got a %T", oldObj)
+	}
+	r, ok := newObj.(*MetaWebHook)
+	if !ok {
+		return fmt.Errorf("expected a MetaWebHook but got a %T", newObj)
+	}
+	metawebhooklog.Info("validate update", "name", r.Name)
+
+	allErrs := field.ErrorList{}
+	if old.Spec.TableId != "" && r.Spec.TableId != old.Spec.TableId {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec", "tableId"), r.Spec.TableId, "field is immutable"))
+	}
+	allErrs = append(allErrs, v.validateSpec(ctx, r)...)
+
+	return v.toInvalid(r, allErrs)
+}
+
+// ValidateDelete implements admission.CustomValidator, deletion is always allowed
+func (v *metaWebHookValidator) ValidateDelete(_ context.Context, _ runtime.Object) error {
+	return nil
+}
+
+func (v *metaWebHookValidator) validateSpec(ctx context.Context, r *MetaWebHook) field.ErrorList {
+	allErrs := field.ErrorList{}
+	specPath := field.NewPath("spec")
+
+	fqnPath := specPath.Child("tableFQN")
+	if r.Spec.TableFQN == "" {
+		return append(allErrs, field.Required(fqnPath, "table fully qualified name is required"))
+	}
+
+	parts := strings.Split(r.Spec.TableFQN, ".")
+	if len(parts) != 4 {
+		return append(allErrs, field.Invalid(fqnPath, r.Spec.TableFQN, "must be service.database.schema.table"))
+	}
+	if r.Spec.Service != "" && parts[0] != r.Spec.Service {
+		allErrs = append(allErrs, field.Invalid(fqnPath, r.Spec.TableFQN, fmt.Sprintf("service must be %s", r.Spec.Service)))
+	}
+	if r.Spec.Database != "" && parts[1] != r.Spec.Database {
+		allErrs = append(allErrs, field.Invalid(fqnPath, r.Spec.TableFQN, fmt.Sprintf("database must be %s", r.Spec.Database)))
+	}
+	if r.Spec.DatabaseSchema != "" && parts[2] != r.Spec.DatabaseSchema {
+		allErrs = append(allErrs, field.Invalid(fqnPath, r.Spec.TableFQN, fmt.Sprintf("schema must be %s", r.Spec.DatabaseSchema)))
+	}
+
+	if v.catalog == nil || len(allErrs) > 0 {
+		return allErrs
+	}
+
+	table, err := v.catalog.GetTableByFQN(ctx, r.Spec.TableFQN)
+	switch {
+	case catalog.IsNotFound(err):
+		allErrs = append(allErrs, field.NotFound(fqnPath, r.Spec.TableFQN))
+	case err != nil:
+		allErrs = append(allErrs, field.InternalError(fqnPath, err))
+	default:
+		if r.Spec.TableId != table.ID {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("tableId"), r.Spec.TableId, fmt.Sprintf("catalog id of %s is %s", r.Spec.TableFQN, table.ID)))
+		}
+		if r.Spec.ServiceType != "" && r.Spec.ServiceType != table.ServiceType {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("serviceType"), r.Spec.ServiceType, fmt.Sprintf("catalog service type of %s is %s", r.Spec.TableFQN, table.ServiceType)))
+		}
+	}
+
+	return allErrs
+}
+
+func (v *metaWebHookValidator) toInvalid(r *MetaWebHook, allErrs field.ErrorList) error {
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(schema.GroupKind{Group: GroupVersion.Group, Kind: "MetaWebHook"}, r.Name, allErrs)
 }
@@ -0,0 +1,89 @@
+package v1
+
+import (
+	"context"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/allenhaozi/webhook/pkg/catalog"
+)
+
+func TestMetaWebHookDefault(t *testing.T) {
+	d := &metaWebHookDefaulter{catalog: catalog.NewFakeClient(catalog.Table{
+		ID:                 "5c3b5d0e",
+		Name:               "naton",
+		FullyQualifiedName: "lakehouse.salesforce.default.naton",
+	})}
+
+	r := &MetaWebHook{Spec: MetaWebHookSpec{
+		Service:        "lakehouse",
+		Database:       "salesforce",
+		DatabaseSchema: "default",
+		TableFQN:       "naton",
+		TableId:        "456",
+	}}
+	if err := d.Default(context.Background(), r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Spec.TableFQN != "lakehouse.salesforce.default.naton" || r.Spec.TableId != "5c3b5d0e" {
+		t.Errorf("unexpected spec %+v", r.Spec)
+	}
+
+	r.Spec.TableFQN = "missing"
+	if err := d.Default(context.Background(), r); err == nil {
+		t.Error("expected an unknown table to be rejected")
+	}
+}
+
+func TestMetaWebHookValidate(t *testing.T) {
+	v := &metaWebHookValidator{catalog: catalog.NewFakeClient(catalog.Table{
+		ID:                 "5c3b5d0e",
+		FullyQualifiedName: "lakehouse.salesforce.default.naton",
+		ServiceType:        "Iceberg",
+	})}
+
+	old := &MetaWebHook{Spec: MetaWebHookSpec{
+		ServiceType:    "Iceberg",
+		Service:        "lakehouse",
+		Database:       "salesforce",
+		DatabaseSchema: "default",
+		TableFQN:       "lakehouse.salesforce.default.naton",
+		TableId:        "5c3b5d0e",
+	}}
+	if err := v.ValidateCreate(context.Background(), old); err != nil {
+		t.Fatal(err)
+	}
+
+	// the catalog is only looked up for a well formed name
+	r := old.DeepCopy()
+	r.Spec.TableFQN = "salesforce.default.naton"
+	if err := v.ValidateCreate(context.Background(), r); err == nil {
+		t.Error("expected a database.schema.table name to be rejected")
+	}
+
+	r = old.DeepCopy()
+	r.Spec.ServiceType = "Mysql"
+	r.Spec.TableId = "456"
+	err := v.ValidateUpdate(context.Background(), old, r)
+
+	statusErr, ok := err.(*apierrors.StatusError)
+	if !ok {
+		t.Fatalf("expected a StatusError, got %v", err)
+	}
+	fields := map[string]bool{}
+	for _, cause := range statusErr.Status().Details.Causes {
+		fields[cause.Field] = true
+	}
+	for _, f := range []string{"spec.serviceType", "spec.tableId"} {
+		if !fields[f] {
+			t.Errorf("expected an error for %s, got %v", f, err)
+		}
+	}
+
+	r = old.DeepCopy()
+	r.Spec.DatabaseSchema = "sales"
+	if err := v.ValidateCreate(context.Background(), r); err == nil {
+		t.Error("expected a table of another schema to be rejected")
+	}
+}
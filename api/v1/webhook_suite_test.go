@@ -37,6 +37,8 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/envtest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/allenhaozi/webhook/pkg/catalog"
 )
 
 // These tests use Ginkgo (BDD-style Go testing framework). Refer to
@@ -99,7 +101,7 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).NotTo(HaveOccurred())
 
-	err = (&MetaWebHook{}).SetupWebhookWithManager(mgr)
+	err = (&MetaWebHook{}).SetupWebhookWithManager(mgr, catalog.NewFakeClient())
 	Expect(err).NotTo(HaveOccurred())
 
 	//+kubebuilder:scaffold:webhook
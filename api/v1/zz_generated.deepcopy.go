@@ -22,6 +22,7 @@ limitations under the License.
 package v1
 
 import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -31,7 +32,7 @@ func (in *MetaWebHook) DeepCopyInto(out *MetaWebHook) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	out.Spec = in.Spec
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetaWebHook.
@@ -102,6 +103,22 @@ func (in *MetaWebHookSpec) DeepCopy() *MetaWebHookSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MetaWebHookStatus) DeepCopyInto(out *MetaWebHookStatus) {
 	*out = *in
+	if in.LastSyncTime != nil {
+		in, out := &in.LastSyncTime, &out.LastSyncTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Table != nil {
+		in, out := &in.Table, &out.Table
+		*out = new(TableMetadata)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetaWebHookStatus.
@@ -113,3 +130,38 @@ func (in *MetaWebHookStatus) DeepCopy() *MetaWebHookStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TableColumn) DeepCopyInto(out *TableColumn) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TableColumn.
+func (in *TableColumn) DeepCopy() *TableColumn {
+	if in == nil {
+		return nil
+	}
+	out := new(TableColumn)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TableMetadata) DeepCopyInto(out *TableMetadata) {
+	*out = *in
+	if in.Columns != nil {
+		in, out := &in.Columns, &out.Columns
+		*out = make([]TableColumn, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TableMetadata.
+func (in *TableMetadata) DeepCopy() *TableMetadata {
+	if in == nil {
+		return nil
+	}
+	out := new(TableMetadata)
+	in.DeepCopyInto(out)
+	return out
+}
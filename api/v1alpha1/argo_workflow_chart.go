@@ -0,0 +1,106 @@
+package v1alpha1
+
+import (
+	"context"
+	"path/filepath"
+
+	argoworkflowv1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+
+	"github.com/allenhaozi/webhook/api/common"
+	openaiosv1alpha1 "github.com/allenhaozi/webhook/api/openaios/v1alpha1"
+	"github.com/allenhaozi/webhook/pkg/helm"
+	"github.com/allenhaozi/webhook/pkg/spark"
+)
+
+// credentialsValuesKey holds the credentials of the values, as in an OperatorDefinition
+const credentialsValuesKey = "credentials"
+
+// renderChart renders the chart referenced by a resource template and returns the
+// manifest of its single resource of the selected kind
+func (a *ArgoWorkflowHandler) renderChart(ctx context.Context, namespace string, tmpl *argoworkflowv1alpha1.Template, values map[string]interface{}) (string, error) {
+	annotations := tmpl.Metadata.Annotations
+
+	if a.ChartDir == "" {
+		return "", errors.New("chart rendering is disabled, no chart directory configured")
+	}
+	// charts are confined to the chart directory
+	chartPath := filepath.Join(a.ChartDir, filepath.Clean("/"+annotations[common.ChartAnnotation]))
+
+	vals := runtime.DeepCopyJSON(values)
+	if inline, ok := annotations[common.ChartValuesAnnotation]; ok {
+		v, err := parseValues(inline)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to parse annotation %s", common.ChartValuesAnnotation)
+		}
+		mergeValues(vals, v)
+	}
+
+	manifests, err := helm.Render(ctx,
+		helm.ChartSource{Path: chartPath},
+		helm.Values{Maps: []map[string]interface{}{vals}},
+		helm.ReleaseOptions{Namespace: namespace},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	kind := annotations[common.ChartKindAnnotation]
+	if kind == "" {
+		kind = common.DefaultChartKind
+	}
+
+	manifest := ""
+	for _, m := range manifests {
+		if m.Kind != kind {
+			continue
+		}
+		if manifest != "" {
+			return "", errors.Errorf("chart %s renders more than one %s", chartPath, kind)
+		}
+		manifest = m.Content
+	}
+
+	if manifest == "" {
+		return "", errors.Errorf("chart %s renders no %s", chartPath, kind)
+	}
+	if kind == common.DefaultChartKind {
+		return injectCredentials(manifest, vals)
+	}
+
+	return manifest, nil
+}
+
+// injectCredentials gives a SparkApplication manifest the credentials of values,
+// laid out as those of an OperatorDefinition, the manifest is kept as is
+// without credentials. Credentials given in plain text are rejected.
+func injectCredentials(manifest string, values map[string]interface{}) (string, error) {
+	raw, ok := values[credentialsValuesKey].(map[string]interface{})
+	if !ok {
+		return manifest, nil
+	}
+	creds := &openaiosv1alpha1.Credentials{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(raw, creds); err != nil {
+		return "", errors.Wrapf(err, "fail to parse the %s values", credentialsValuesKey)
+	}
+	if errs := openaiosv1alpha1.ValidateCredentials(field.NewPath(credentialsValuesKey), creds); len(errs) > 0 {
+		return "", errs.ToAggregate()
+	}
+
+	app := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(manifest), &app); err != nil {
+		return "", errors.Wrapf(err, "fail to parse %s", common.DefaultChartKind)
+	}
+	if err := spark.InjectCredentials(app, creds); err != nil {
+		return "", errors.Wrap(err, "fail to inject credentials")
+	}
+	out, err := yaml.Marshal(app)
+	if err != nil {
+		return "", err
+	}
+
+	return string(out), nil
+}
@@ -0,0 +1,215 @@
+package v1alpha1
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	argoworkflowv1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/allenhaozi/webhook/api/common"
+)
+
+func TestAdmitRenderChart(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = AddToScheme(scheme)
+
+	a := &ArgoWorkflowHandler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Log:      logr.Discard(),
+		ChartDir: "../../charts",
+	}
+	workflow := &argoworkflowv1alpha1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "pi"},
+		Spec: argoworkflowv1alpha1.WorkflowSpec{
+			Templates: []argoworkflowv1alpha1.Template{{
+				Name: "salesforecast",
+				Metadata: argoworkflowv1alpha1.Metadata{Annotations: map[string]string{
+					common.ChartAnnotation:       "salesforecast",
+					common.ChartValuesAnnotation: "runtimeConfig:\n  image:\n    tag: v3.3.0\n",
+				}},
+				// the manifest is left out, the chart renders it
+				Resource: &argoworkflowv1alpha1.ResourceTemplate{Action: "create"},
+			}},
+		},
+	}
+	req := admission.Request{}
+	req.Namespace = "team"
+
+	resp := a.admit(context.Background(), req, workflow, &workflow.Spec, workflow.Spec.Arguments, "/spec")
+	if !resp.Allowed {
+		t.Fatalf("workflow denied: %v", resp.Result)
+	}
+	if len(resp.Patches) != 1 || resp.Patches[0].Operation != "add" || resp.Patches[0].Path != "/spec/templates/0/resource/manifest" {
+		t.Fatalf("expected the manifest to be added, got %v", resp.Patches)
+	}
+	manifest, _ := resp.Patches[0].Value.(string)
+	if !strings.Contains(manifest, "kind: SparkApplication") || !strings.Contains(manifest, "image: gcr.io/spark-operator/spark-py:v3.3.0") {
+		t.Errorf("unexpected manifest %s", manifest)
+	}
+
+	// the rendered manifest is kept as is
+	workflow.Spec.Templates[0].Resource.Manifest = manifest
+	resp = a.admit(context.Background(), req, workflow, &workflow.Spec, workflow.Spec.Arguments, "/spec")
+	if !resp.Allowed || len(resp.Patches) != 0 {
+		t.Fatalf("expected a rendered manifest to be left as is, got %v %v", resp.Result, resp.Patches)
+	}
+
+	// a stale manifest is replaced
+	workflow.Spec.Templates[0].Resource.Manifest = "kind: SparkApplication\n"
+	resp = a.admit(context.Background(), req, workflow, &workflow.Spec, workflow.Spec.Arguments, "/spec")
+	if !resp.Allowed || len(resp.Patches) != 1 || resp.Patches[0].Operation != "replace" || resp.Patches[0].Value != manifest {
+		t.Errorf("expected the stale manifest to be replaced, got %v %v", resp.Result, resp.Patches)
+	}
+}
+
+func TestRenderChart(t *testing.T) {
+	// charts holds the chart directory, next to a chart outside of it
+	dir := t.TempDir()
+	sparkApplication := "apiVersion: sparkoperator.k8s.io/v1beta2\nkind: SparkApplication\nmetadata:\n  name: %s\n"
+	for chart, templates := range map[string]map[string]string{
+		"charts/twice": {"first.yaml": sparkApplication, "second.yaml": sparkApplication},
+		"outside":      {"spark.yaml": sparkApplication},
+	} {
+		writeChart(t, filepath.Join(dir, chart), templates)
+	}
+	a := &ArgoWorkflowHandler{Log: logr.Discard(), ChartDir: filepath.Join(dir, "charts")}
+
+	for _, tc := range []struct {
+		name        string
+		chartDir    string
+		annotations map[string]string
+		kind        string
+		err         string
+	}{
+		{
+			name:        "default kind",
+			chartDir:    "../../charts",
+			annotations: map[string]string{common.ChartAnnotation: "salesforecast"},
+			kind:        "SparkApplication",
+		},
+		{
+			name:        "selected kind",
+			chartDir:    "../../charts",
+			annotations: map[string]string{common.ChartAnnotation: "salesforecast", common.ChartKindAnnotation: "SparkApplication"},
+			kind:        "SparkApplication",
+		},
+		{
+			name:        "kind not rendered",
+			chartDir:    "../../charts",
+			annotations: map[string]string{common.ChartAnnotation: "salesforecast", common.ChartKindAnnotation: "ConfigMap"},
+			err:         "renders no ConfigMap",
+		},
+		{
+			name:        "kind rendered twice",
+			annotations: map[string]string{common.ChartAnnotation: "twice"},
+			err:         "renders more than one SparkApplication",
+		},
+		{
+			name:        "chart outside of the chart directory",
+			annotations: map[string]string{common.ChartAnnotation: "../outside"},
+			err:         filepath.Join(dir, "charts", "outside"),
+		},
+		{
+			name:        "chart escaping to the root",
+			annotations: map[string]string{common.ChartAnnotation: "../../../../../../etc"},
+			err:         filepath.Join(dir, "charts", "etc"),
+		},
+		{
+			name:        "invalid chart values",
+			chartDir:    "../../charts",
+			annotations: map[string]string{common.ChartAnnotation: "salesforecast", common.ChartValuesAnnotation: "- image"},
+			err:         "failed to parse annotation " + common.ChartValuesAnnotation,
+		},
+		{
+			name:        "chart rendering disabled",
+			chartDir:    "-",
+			annotations: map[string]string{common.ChartAnnotation: "salesforecast"},
+			err:         "chart rendering is disabled",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := *a
+			switch tc.chartDir {
+			case "":
+			case "-":
+				h.ChartDir = ""
+			default:
+				h.ChartDir = tc.chartDir
+			}
+			tmpl := &argoworkflowv1alpha1.Template{Name: "spark", Metadata: argoworkflowv1alpha1.Metadata{Annotations: tc.annotations}}
+
+			manifest, err := h.renderChart(context.Background(), "team", tmpl, map[string]interface{}{})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected an error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(manifest, "kind: "+tc.kind) {
+				t.Errorf("expected a %s, got %s", tc.kind, manifest)
+			}
+		})
+	}
+}
+
+// writeChart writes a chart of templates at path, %s in a template is replaced by its name
+func writeChart(t *testing.T, path string, templates map[string]string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(path, "templates"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	chart := "apiVersion: v2\nname: " + filepath.Base(path) + "\nversion: 0.1.0\n"
+	if err := os.WriteFile(filepath.Join(path, "Chart.yaml"), []byte(chart), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range templates {
+		content = strings.ReplaceAll(content, "%s", strings.TrimSuffix(name, ".yaml"))
+		if err := os.WriteFile(filepath.Join(path, "templates", name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestInjectCredentials(t *testing.T) {
+	manifest := "kind: SparkApplication\nspec:\n  type: Python\n"
+
+	injected, err := injectCredentials(manifest, map[string]interface{}{
+		"credentials": map[string]interface{}{"hdfs": []interface{}{map[string]interface{}{
+			"name":                      "hdfs-default",
+			"hadoopConfigurationDir":    "/opt/spark/etc/hdfs-default",
+			"hadoopConfigurationSecret": "hdfs-default-conf",
+		}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(injected, "HADOOP_CONF_DIR: /opt/spark/etc/hdfs-default") {
+		t.Errorf("expected the hadoop configuration to be mounted, got %s", injected)
+	}
+
+	// secrets may not be given in plain text
+	_, err = injectCredentials(manifest, map[string]interface{}{
+		"credentials": map[string]interface{}{"hdfs": []interface{}{map[string]interface{}{
+			"name":                      "hdfs-default",
+			"hadoopConfigurationDir":    "/opt/spark/etc/hdfs-default",
+			"hadoopConfigurationSecret": "hdfs-default-conf",
+			"hadoopConfiguration":       map[string]interface{}{"core-site.xml": "<configuration/>"},
+		}}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "credentials.hdfs[0].hadoopConfiguration") {
+		t.Errorf("expected the plain text hadoop configuration to be rejected, got %v", err)
+	}
+}
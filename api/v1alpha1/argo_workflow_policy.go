@@ -0,0 +1,211 @@
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"regexp"
+	"sort"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/allenhaozi/webhook/api/common"
+)
+
+// +kubebuilder:rbac:groups=meta.github.com,resources=manifestrenderpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+// manifestKindRegexp finds the kind of an inline manifest, the manifest is a
+// template and may not parse as YAML before it is rendered
+var manifestKindRegexp = regexp.MustCompile(`(?m)^kind:\s*["']?([A-Za-z0-9]+)`)
+
+// renderPolicy is a ManifestRenderPolicy selecting the admitted object
+type renderPolicy struct {
+	name string
+	// kinds is empty when the policy applies to every kind
+	kinds  sets.String
+	values map[string]interface{}
+}
+
+// policySet holds the ManifestRenderPolicies selecting an admitted object in order
+type policySet []renderPolicy
+
+// renderPolicies resolves the ManifestRenderPolicies selecting obj of namespace,
+// ordered by name then namespace. Cluster scoped objects are selected by no policy.
+// Only the policies of PolicyNamespace select objects of other namespaces, a
+// policy failing to resolve, e.g. reading a missing ConfigMap, is logged and left out.
+// The policies are read from the API server, like the ConfigMaps and Namespaces
+// they refer to, the webhook doesn't cache them.
+func (a *ArgoWorkflowHandler) renderPolicies(ctx context.Context, namespace string, obj metav1.Object) (policySet, error) {
+	if namespace == "" {
+		return nil, nil
+	}
+
+	list := &ManifestRenderPolicyList{}
+	if err := a.Client.List(ctx, list); err != nil {
+		return nil, errors.Wrap(err, "failed to list manifest render policies")
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		if list.Items[i].Name != list.Items[j].Name {
+			return list.Items[i].Name < list.Items[j].Name
+		}
+		return list.Items[i].Namespace < list.Items[j].Namespace
+	})
+
+	var ns *corev1.Namespace
+	policies := policySet{}
+	for i := range list.Items {
+		p := &list.Items[i]
+		key := apitypes.NamespacedName{Namespace: p.Namespace, Name: p.Name}
+
+		if p.Namespace != namespace && (p.Spec.NamespaceSelector == nil || a.PolicyNamespace == "" || p.Namespace != a.PolicyNamespace) {
+			continue
+		}
+		if p.Spec.NamespaceSelector != nil {
+			if ns == nil {
+				ns = &corev1.Namespace{}
+				if err := a.Client.Get(ctx, apitypes.NamespacedName{Name: namespace}, ns); err != nil {
+					return nil, errors.Wrapf(err, "failed to get namespace %s", namespace)
+				}
+			}
+			ok, err := selectorMatches(p.Spec.NamespaceSelector, ns.Labels)
+			if err != nil {
+				a.Log.Error(err, "skip manifest render policy, invalid namespaceSelector", "policy", key)
+				continue
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		if p.Spec.WorkflowSelector != nil {
+			ok, err := selectorMatches(p.Spec.WorkflowSelector, obj.GetLabels())
+			if err != nil {
+				a.Log.Error(err, "skip manifest render policy, invalid workflowSelector", "policy", key)
+				continue
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		values, err := a.policyValues(ctx, p)
+		if err != nil {
+			a.Log.Error(err, "skip manifest render policy", "policy", key)
+			continue
+		}
+		policies = append(policies, renderPolicy{
+			name:   key.String(),
+			kinds:  sets.NewString(p.Spec.TargetKinds...),
+			values: values,
+		})
+	}
+
+	return policies, nil
+}
+
+// policyValues merges the value sources of a policy, then its inline values
+func (a *ArgoWorkflowHandler) policyValues(ctx context.Context, p *ManifestRenderPolicy) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+
+	for _, src := range p.Spec.ValuesFrom {
+		data, err := a.readValuesSource(ctx, p.Namespace, src)
+		if err != nil {
+			return nil, err
+		}
+		v, err := parseValues(data)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse values source")
+		}
+		mergeValues(values, v)
+	}
+
+	if p.Spec.Values != nil && len(p.Spec.Values.Raw) > 0 {
+		v := map[string]interface{}{}
+		if err := json.Unmarshal(p.Spec.Values.Raw, &v); err != nil {
+			return nil, errors.Wrap(err, "failed to parse values")
+		}
+		mergeValues(values, v)
+	}
+
+	return values, nil
+}
+
+// readValuesSource returns the data of the key a values source references, an
+// optional reference to a missing object or key reads as empty
+func (a *ArgoWorkflowHandler) readValuesSource(ctx context.Context, namespace string, src ValuesSource) (string, error) {
+	ref := src.ConfigMapKeyRef
+	if ref == nil {
+		return "", errors.New("a values source must set configMapKeyRef")
+	}
+	optional := ref.Optional != nil && *ref.Optional
+	objectKey := apitypes.NamespacedName{Namespace: namespace, Name: ref.Name}
+
+	cm := &corev1.ConfigMap{}
+	if err := a.Client.Get(ctx, objectKey, cm); err != nil {
+		if optional && apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", errors.Wrapf(err, "failed to get values configmap %s", objectKey)
+	}
+	data, ok := cm.Data[ref.Key]
+	if !ok && !optional {
+		return "", errors.Errorf("key %s not found in configmap %s", ref.Key, objectKey)
+	}
+	return data, nil
+}
+
+// forKind returns the values of the policies applying to kind merged in order,
+// overridden by the values of the workflow
+func (p policySet) forKind(kind string, values map[string]interface{}) map[string]interface{} {
+	if len(p) == 0 {
+		return values
+	}
+
+	merged := map[string]interface{}{}
+	for _, policy := range p {
+		if policy.kinds.Len() > 0 && !policy.kinds.Has(kind) {
+			continue
+		}
+		mergeValues(merged, runtime.DeepCopyJSON(policy.values))
+	}
+	mergeValues(merged, runtime.DeepCopyJSON(values))
+
+	return merged
+}
+
+func (p policySet) names() []string {
+	names := make([]string, 0, len(p))
+	for _, policy := range p {
+		names = append(names, policy.name)
+	}
+	return names
+}
+
+// manifestKind returns the kind of the manifest a resource template renders
+func manifestKind(annotations map[string]string, manifest string) string {
+	if _, ok := annotations[common.ChartAnnotation]; ok {
+		if kind := annotations[common.ChartKindAnnotation]; kind != "" {
+			return kind
+		}
+		return common.DefaultChartKind
+	}
+	if m := manifestKindRegexp.FindStringSubmatch(manifest); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func selectorMatches(selector *metav1.LabelSelector, set map[string]string) (bool, error) {
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return false, err
+	}
+	return s.Matches(labels.Set(set)), nil
+}
@@ -0,0 +1,146 @@
+package v1alpha1
+
+import (
+	"context"
+	"testing"
+
+	argoworkflowv1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/allenhaozi/webhook/api/common"
+	"github.com/allenhaozi/webhook/pkg/engine"
+)
+
+func TestAdmitRenderPolicies(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = AddToScheme(scheme)
+
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team", Labels: map[string]string{"tier": "batch"}}}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "registry"},
+		Data:       map[string]string{"values.yaml": "registry: r.io\nqueue: from-configmap\n"},
+	}
+	base := &ManifestRenderPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "a-base"},
+		Spec: ManifestRenderPolicySpec{
+			ValuesFrom: []ValuesSource{{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "registry"},
+				Key:                  "values.yaml",
+			}}},
+			Values: &apiextensionsv1.JSON{Raw: []byte(`{"image":"spark:3"}`)},
+		},
+	}
+	spark := &ManifestRenderPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "platform", Name: "b-spark"},
+		Spec: ManifestRenderPolicySpec{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "batch"}},
+			TargetKinds:       []string{"SparkApplication"},
+			Values:            &apiextensionsv1.JSON{Raw: []byte(`{"queue":"spark"}`)},
+		},
+	}
+	other := &ManifestRenderPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "c-other"},
+		Spec: ManifestRenderPolicySpec{
+			WorkflowSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "other"}},
+			Values:           &apiextensionsv1.JSON{Raw: []byte(`{"queue":"other"}`)},
+		},
+	}
+
+	// a policy outside the policy namespace doesn't reach other namespaces
+	everywhere := &ManifestRenderPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "other-team", Name: "d-everywhere"},
+		Spec: ManifestRenderPolicySpec{
+			NamespaceSelector: &metav1.LabelSelector{},
+			Values:            &apiextensionsv1.JSON{Raw: []byte(`{"queue":"everywhere"}`)},
+		},
+	}
+	// a policy failing to resolve is left out
+	broken := &ManifestRenderPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "e-broken"},
+		Spec: ManifestRenderPolicySpec{
+			ValuesFrom: []ValuesSource{{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: "missing"},
+				Key:                  "values.yaml",
+			}}},
+			Values: &apiextensionsv1.JSON{Raw: []byte(`{"queue":"broken"}`)},
+		},
+	}
+	// as is a values source without configMapKeyRef, e.g. a pruned secretKeyRef
+	pruned := &ManifestRenderPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "f-pruned"},
+		Spec: ManifestRenderPolicySpec{
+			ValuesFrom: []ValuesSource{{}},
+			Values:     &apiextensionsv1.JSON{Raw: []byte(`{"queue":"pruned"}`)},
+		},
+	}
+
+	a := &ArgoWorkflowHandler{
+		Client:          fake.NewClientBuilder().WithScheme(scheme).WithObjects(ns, cm, base, spark, other, everywhere, broken, pruned).Build(),
+		Log:             logr.Discard(),
+		PolicyNamespace: "platform",
+	}
+
+	goEngine := argoworkflowv1alpha1.Metadata{Annotations: map[string]string{common.TemplateEngineAnnotation: engine.Go}}
+	workflow := &argoworkflowv1alpha1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "pi"},
+		Spec: argoworkflowv1alpha1.WorkflowSpec{
+			Arguments: argoworkflowv1alpha1.Arguments{Parameters: []argoworkflowv1alpha1.Parameter{
+				{Name: "image", Value: argoworkflowv1alpha1.AnyStringPtr("spark:3.3")},
+			}},
+			Templates: []argoworkflowv1alpha1.Template{
+				{Name: "spark", Metadata: goEngine, Resource: &argoworkflowv1alpha1.ResourceTemplate{
+					Manifest: "kind: SparkApplication\nimage: {{ .registry }}/{{ .image }}\nqueue: {{ .queue }}\n",
+				}},
+				{Name: "config", Metadata: goEngine, Resource: &argoworkflowv1alpha1.ResourceTemplate{
+					Manifest: "kind: ConfigMap\nqueue: {{ .queue }}\n",
+				}},
+				// a policy selecting the workflow doesn't opt the template into rendering
+				{Name: "argo", Resource: &argoworkflowv1alpha1.ResourceTemplate{
+					Manifest: "kind: SparkApplication\nname: {{workflow.name}}\n",
+				}},
+			},
+		},
+	}
+	req := admission.Request{}
+	req.Namespace = "team"
+
+	resp := a.admit(context.Background(), req, workflow, &workflow.Spec, workflow.Spec.Arguments, "/spec")
+	if !resp.Allowed {
+		t.Fatalf("workflow denied: %v", resp.Result)
+	}
+
+	expected := map[string]string{
+		"/spec/templates/0/resource/manifest": "kind: SparkApplication\nimage: r.io/spark:3.3\nqueue: spark\n",
+		"/spec/templates/1/resource/manifest": "kind: ConfigMap\nqueue: from-configmap\n",
+	}
+	if len(resp.Patches) != len(expected) {
+		t.Fatalf("expected %d patches, got %v", len(expected), resp.Patches)
+	}
+	for _, p := range resp.Patches {
+		if p.Value != expected[p.Path] {
+			t.Errorf("unexpected manifest of %s: %q", p.Path, p.Value)
+		}
+	}
+
+	// without a policy namespace no policy crosses namespaces
+	a.PolicyNamespace = ""
+	resp = a.admit(context.Background(), req, workflow, &workflow.Spec, workflow.Spec.Arguments, "/spec")
+	if !resp.Allowed || len(resp.Patches) != 2 || resp.Patches[0].Value != "kind: SparkApplication\nimage: r.io/spark:3.3\nqueue: from-configmap\n" {
+		t.Errorf("expected the platform policy to be left out, got %v", resp.Patches)
+	}
+
+	// cluster scoped objects are selected by no policy
+	req.Namespace = ""
+	resp = a.admit(context.Background(), req, workflow, &workflow.Spec, workflow.Spec.Arguments, "/spec")
+	if resp.Allowed {
+		t.Fatalf("expected missing values to deny the workflow")
+	}
+}
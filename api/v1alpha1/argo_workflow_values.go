@@ -0,0 +1,93 @@
+package v1alpha1
+
+import (
+	"context"
+	"strings"
+
+	argoworkflowv1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/yaml"
+
+	"github.com/allenhaozi/webhook/api/common"
+)
+
+// workflowValues collects the values used to render the resource manifests of a workflow,
+// obj is the admitted workflow, workflow template or cron workflow.
+// Sources are merged in order, a later source overrides an earlier one:
+//  1. the ConfigMap named by the values-from annotation, key values.yaml
+//  2. the inline values annotation
+//  3. the parameters of arguments, each parameter becomes a top level key,
+//     workflow templates are rendered without arguments
+//
+// The values override those of the ManifestRenderPolicies selecting obj, see renderPolicies.
+func (a *ArgoWorkflowHandler) workflowValues(ctx context.Context, namespace string, obj metav1.Object, arguments argoworkflowv1alpha1.Arguments) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+
+	annotations := obj.GetAnnotations()
+
+	if name, ok := annotations[common.ValuesFromAnnotation]; ok && name != "" {
+		if namespace == "" {
+			return nil, errors.Errorf("annotation %s is not supported by cluster scoped objects", common.ValuesFromAnnotation)
+		}
+		cm := &corev1.ConfigMap{}
+		objectKey := apitypes.NamespacedName{Namespace: namespace, Name: name}
+		if err := a.Client.Get(ctx, objectKey, cm); err != nil {
+			return nil, errors.Wrapf(err, "failed to get values configmap %s", objectKey)
+		}
+		v, err := parseValues(cm.Data[common.ValuesConfigMapKey])
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse %s of configmap %s", common.ValuesConfigMapKey, objectKey)
+		}
+		mergeValues(values, v)
+	}
+
+	if inline, ok := annotations[common.ValuesAnnotation]; ok {
+		v, err := parseValues(inline)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse annotation %s", common.ValuesAnnotation)
+		}
+		mergeValues(values, v)
+	}
+
+	for _, p := range arguments.Parameters {
+		var value *argoworkflowv1alpha1.AnyString
+		switch {
+		case p.Value != nil:
+			value = p.Value
+		case p.Default != nil:
+			value = p.Default
+		default:
+			continue
+		}
+		values[p.Name] = value.String()
+	}
+
+	return values, nil
+}
+
+func parseValues(data string) (map[string]interface{}, error) {
+	values := map[string]interface{}{}
+	if strings.TrimSpace(data) == "" {
+		return values, nil
+	}
+	if err := yaml.Unmarshal([]byte(data), &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// mergeValues deep merges src into dst, nested maps are merged key by key
+func mergeValues(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if srcMap, ok := v.(map[string]interface{}); ok {
+			if dstMap, ok := dst[k].(map[string]interface{}); ok {
+				mergeValues(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[k] = v
+	}
+}
@@ -0,0 +1,192 @@
+package v1alpha1
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	argoworkflowv1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/allenhaozi/webhook/api/common"
+)
+
+func TestWorkflowValues(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "spark-values"},
+		Data: map[string]string{
+			common.ValuesConfigMapKey: "image: spark:3\nqueue: default\ndriver:\n  cores: 1\n  memory: 512m\n",
+		},
+	}
+	broken := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "broken"},
+		Data:       map[string]string{common.ValuesConfigMapKey: "image: [spark"},
+	}
+	a := &ArgoWorkflowHandler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(cm, broken).Build(),
+		Log:    logr.Discard(),
+	}
+
+	for _, tc := range []struct {
+		name        string
+		namespace   string
+		annotations map[string]string
+		parameters  []argoworkflowv1alpha1.Parameter
+		expected    map[string]interface{}
+		err         string
+	}{
+		{
+			name:      "no values",
+			namespace: "team",
+			expected:  map[string]interface{}{},
+		},
+		{
+			name:      "configmap < annotation < parameters",
+			namespace: "team",
+			annotations: map[string]string{
+				common.ValuesFromAnnotation: "spark-values",
+				common.ValuesAnnotation:     "queue: batch\ndriver:\n  cores: 2\n",
+			},
+			parameters: []argoworkflowv1alpha1.Parameter{
+				{Name: "image", Value: argoworkflowv1alpha1.AnyStringPtr("spark:3.3")},
+			},
+			expected: map[string]interface{}{
+				"image":  "spark:3.3",
+				"queue":  "batch",
+				"driver": map[string]interface{}{"cores": float64(2), "memory": "512m"},
+			},
+		},
+		{
+			name:      "default in place of a missing value",
+			namespace: "team",
+			parameters: []argoworkflowv1alpha1.Parameter{
+				{Name: "image", Default: argoworkflowv1alpha1.AnyStringPtr("spark:3")},
+				{Name: "queue", Value: argoworkflowv1alpha1.AnyStringPtr("batch"), Default: argoworkflowv1alpha1.AnyStringPtr("default")},
+				{Name: "unset"},
+			},
+			expected: map[string]interface{}{"image": "spark:3", "queue": "batch"},
+		},
+		{
+			name:        "cluster scoped values-from",
+			annotations: map[string]string{common.ValuesFromAnnotation: "spark-values"},
+			err:         "not supported by cluster scoped objects",
+		},
+		{
+			name:        "cluster scoped inline values",
+			annotations: map[string]string{common.ValuesAnnotation: "queue: batch"},
+			expected:    map[string]interface{}{"queue": "batch"},
+		},
+		{
+			name:        "missing configmap",
+			namespace:   "team",
+			annotations: map[string]string{common.ValuesFromAnnotation: "missing"},
+			err:         "failed to get values configmap team/missing",
+		},
+		{
+			name:        "invalid configmap values",
+			namespace:   "team",
+			annotations: map[string]string{common.ValuesFromAnnotation: "broken"},
+			err:         "failed to parse values.yaml of configmap team/broken",
+		},
+		{
+			name:        "invalid annotation values",
+			namespace:   "team",
+			annotations: map[string]string{common.ValuesAnnotation: "- queue"},
+			err:         "failed to parse annotation " + common.ValuesAnnotation,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			obj := &argoworkflowv1alpha1.Workflow{ObjectMeta: metav1.ObjectMeta{Namespace: tc.namespace, Name: "pi", Annotations: tc.annotations}}
+			values, err := a.workflowValues(context.Background(), tc.namespace, obj, argoworkflowv1alpha1.Arguments{Parameters: tc.parameters})
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected an error containing %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(values, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, values)
+			}
+		})
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	for _, tc := range []struct {
+		data     string
+		expected map[string]interface{}
+		err      bool
+	}{
+		{data: "", expected: map[string]interface{}{}},
+		{data: " \n", expected: map[string]interface{}{}},
+		{data: "a: 1\nb:\n  c: x\n", expected: map[string]interface{}{"a": float64(1), "b": map[string]interface{}{"c": "x"}}},
+		{data: "- a", err: true},
+		{data: "a: [1", err: true},
+	} {
+		values, err := parseValues(tc.data)
+		if (err != nil) != tc.err {
+			t.Errorf("%q: unexpected error %v", tc.data, err)
+			continue
+		}
+		if !tc.err && !reflect.DeepEqual(values, tc.expected) {
+			t.Errorf("%q: expected %v, got %v", tc.data, tc.expected, values)
+		}
+	}
+}
+
+func TestMergeValues(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		dst      map[string]interface{}
+		src      map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			name:     "override",
+			dst:      map[string]interface{}{"a": "1", "b": "2"},
+			src:      map[string]interface{}{"a": "3"},
+			expected: map[string]interface{}{"a": "3", "b": "2"},
+		},
+		{
+			name:     "nested maps merged key by key",
+			dst:      map[string]interface{}{"driver": map[string]interface{}{"cores": 1, "memory": "512m"}},
+			src:      map[string]interface{}{"driver": map[string]interface{}{"cores": 2}},
+			expected: map[string]interface{}{"driver": map[string]interface{}{"cores": 2, "memory": "512m"}},
+		},
+		{
+			name:     "map replaces a scalar",
+			dst:      map[string]interface{}{"driver": "small"},
+			src:      map[string]interface{}{"driver": map[string]interface{}{"cores": 2}},
+			expected: map[string]interface{}{"driver": map[string]interface{}{"cores": 2}},
+		},
+		{
+			name:     "scalar replaces a map",
+			dst:      map[string]interface{}{"driver": map[string]interface{}{"cores": 2}},
+			src:      map[string]interface{}{"driver": "small"},
+			expected: map[string]interface{}{"driver": "small"},
+		},
+		{
+			name:     "lists replaced",
+			dst:      map[string]interface{}{"args": []interface{}{"a", "b"}},
+			src:      map[string]interface{}{"args": []interface{}{"c"}},
+			expected: map[string]interface{}{"args": []interface{}{"c"}},
+		},
+	} {
+		mergeValues(tc.dst, tc.src)
+		if !reflect.DeepEqual(tc.dst, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, tc.dst)
+		}
+	}
+}
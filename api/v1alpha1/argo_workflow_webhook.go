@@ -1,27 +1,39 @@
 package v1alpha1
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
-	"log"
+	"fmt"
 	"net/http"
-	"os"
-	"text/template"
+	"reflect"
+	"strings"
 
 	argoworkflowv1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
 	"github.com/go-logr/logr"
-	"helm.sh/helm/v3/pkg/action"
-	"helm.sh/helm/v3/pkg/chart/loader"
-	"helm.sh/helm/v3/pkg/cli"
+	"gomodules.xyz/jsonpatch/v2"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/allenhaozi/webhook/api/common"
+	"github.com/allenhaozi/webhook/pkg/engine"
 )
 
 type ArgoWorkflowHandler struct {
-	Client  client.Client
+	// Client reads the values sources and the render policies, it is meant to
+	// be the uncached reader of the manager: a cached client would watch every
+	// ConfigMap, Namespace and policy of the cluster
+	Client  client.Reader
 	decoder *admission.Decoder
 	Log     logr.Logger
+	// ChartDir holds the charts resource templates may reference, chart rendering is disabled when empty
+	ChartDir string
+	// PolicyNamespace holds the ManifestRenderPolicies whose namespaceSelector may
+	// select workflows of other namespaces, the policies of other namespaces only
+	// select workflows of their own namespace
+	PolicyNamespace string
 }
 
 //+kubebuilder:webhook:path=/mutate-v1alpha1-argoworkflow,mutating=true,failurePolicy=fail,sideEffects=None,groups=argoproj.io,resources=workflows,verbs=create;update,versions=v1alpha1,name=mworkflow.argoproj.io,admissionReviewVersions=v1
@@ -30,10 +42,12 @@ type ArgoWorkflowHandler struct {
 // +kubebuilder:rbac:groups=workflow.argoproj.io,resources=workflows/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=workflow.argoproj.io,resources=workflows/finalizers,verbs=update
 
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+
 // +kubebuilder:rbac:groups=sparkoperator.k8s.io,resources=sparkapplications,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=sparkoperator.k8s.io,resources=sparkapplications/status,verbs=get;update;patch
 
-// podAnnotator adds an annotation to every incoming pods.
+// Handle renders the manifests of the resource templates of an incoming workflow.
 func (a *ArgoWorkflowHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
 	workflow := &argoworkflowv1alpha1.Workflow{}
 
@@ -42,36 +56,144 @@ func (a *ArgoWorkflowHandler) Handle(ctx context.Context, req admission.Request)
 		return admission.Errored(http.StatusBadRequest, err)
 	}
 	a.Log.Info("Workflow webhook Handle", "got workflow: %v", workflow)
-	for k, v := range workflow.Spec.Templates {
-		v := v
-		manifest := v.Resource.Manifest
-		manifest = a.process(manifest)
-		v.Resource.Manifest = manifest
-		workflow.Spec.Templates[k] = v
+
+	return a.admit(ctx, req, workflow, &workflow.Spec, workflow.Spec.Arguments, "/spec")
+}
+
+// admit renders the resource templates of a workflow spec, basePath is the JSON
+// pointer of the spec inside the admitted object. obj carries the values annotations,
+// arguments are the parameters rendering the manifests.
+//
+// The values are only resolved when a manifest is rendered, and an update
+// leaving the spec as is, e.g. of the status by the workflow controller, is
+// admitted as is: the spec was rendered when it last changed, the values
+// sources may be gone since.
+func (a *ArgoWorkflowHandler) admit(ctx context.Context, req admission.Request, obj metav1.Object, spec *argoworkflowv1alpha1.WorkflowSpec, arguments argoworkflowv1alpha1.Arguments, basePath string) admission.Response {
+	if req.Operation == admissionv1.Update && specUnchanged(req, basePath) {
+		return admission.Allowed("spec unchanged")
 	}
-	a.Log.Info("Workflow webhook Handle", "render workflow: %v", workflow)
 
-	marshaledWorkflow, err := json.Marshal(workflow)
+	rendered := []int{}
+	for k, v := range spec.Templates {
+		// only resource templates carry a manifest, container, script, dag,
+		// steps and suspend templates are left untouched
+		if v.Resource == nil {
+			continue
+		}
+		// manifests may hold Argo expressions such as {{inputs.parameters.name}},
+		// only those asking for it are rendered
+		if _, ok := v.Metadata.Annotations[common.ChartAnnotation]; ok || (v.Resource.Manifest != "" && renderRequested(&v)) {
+			rendered = append(rendered, k)
+		}
+	}
+	if len(rendered) == 0 {
+		return admission.Allowed("no resource manifest to render")
+	}
+
+	values, err := a.workflowValues(ctx, req.Namespace, obj, arguments)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	policies, err := a.renderPolicies(ctx, req.Namespace, obj)
 	if err != nil {
 		return admission.Errored(http.StatusInternalServerError, err)
 	}
+	if len(policies) > 0 {
+		a.Log.Info("Workflow webhook Handle", "kind", req.Kind.Kind, "name", obj.GetName(), "render policies", policies.names())
+	}
+
+	patches := []jsonpatch.JsonPatchOperation{}
+	for _, k := range rendered {
+		v := spec.Templates[k]
+		manifestPath := fmt.Sprintf("%s/templates/%d/resource/manifest", basePath, k)
+		tmplValues := policies.forKind(manifestKind(v.Metadata.Annotations, v.Resource.Manifest), values)
+
+		if _, ok := v.Metadata.Annotations[common.ChartAnnotation]; ok {
+			manifest, err := a.renderChart(ctx, req.Namespace, &v, tmplValues)
+			if err != nil {
+				a.Log.Info("reject workflow", "kind", req.Kind.Kind, "name", obj.GetName(), "reason", err.Error())
+				return admission.Denied(fmt.Sprintf("template %q: %s", v.Name, err.Error()))
+			}
+			if manifest == v.Resource.Manifest {
+				continue
+			}
+			// the manifest may be absent from a chart backed template, it is added then
+			op := "replace"
+			if v.Resource.Manifest == "" {
+				op = "add"
+			}
+			patches = append(patches, jsonpatch.JsonPatchOperation{
+				Operation: op,
+				Path:      manifestPath,
+				Value:     manifest,
+			})
+			continue
+		}
+
+		manifest, err := a.process(v.Name, v.Metadata.Annotations[common.TemplateEngineAnnotation], v.Resource.Manifest, tmplValues)
+		if err != nil {
+			a.Log.Info("reject workflow", "kind", req.Kind.Kind, "name", obj.GetName(), "reason", err.Error())
+			return admission.Denied(err.Error())
+		}
+		if manifest == v.Resource.Manifest {
+			continue
+		}
+		patches = append(patches, jsonpatch.JsonPatchOperation{
+			Operation: "replace",
+			Path:      manifestPath,
+			Value:     manifest,
+		})
+	}
+
+	if len(patches) == 0 {
+		return admission.Allowed("no resource manifest to render")
+	}
+	a.Log.Info("Workflow webhook Handle", "kind", req.Kind.Kind, "name", obj.GetName(), "rendered manifests", len(patches))
 
-	return admission.PatchResponseFromRaw(req.Object.Raw, marshaledWorkflow)
+	return admission.Patched("render resource manifests", patches...)
 }
 
-func (a *ArgoWorkflowHandler) process(manifest string) string {
-	var buf bytes.Buffer
-	m := map[string]interface{}{}
+// specUnchanged tells whether an update leaves the spec at basePath as is
+func specUnchanged(req admission.Request, basePath string) bool {
+	if len(req.OldObject.Raw) == 0 {
+		return false
+	}
+	fields := strings.Split(strings.TrimPrefix(basePath, "/"), "/")
+	specs := []interface{}{}
+	for _, raw := range [][]byte{req.Object.Raw, req.OldObject.Raw} {
+		obj := map[string]interface{}{}
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			return false
+		}
+		spec, _, _ := unstructured.NestedFieldNoCopy(obj, fields...)
+		specs = append(specs, spec)
+	}
+	return reflect.DeepEqual(specs[0], specs[1])
+}
 
-	core := make(map[string]string, 0)
-	core["cores"] = "1"
+// renderRequested tells whether the manifest of tmpl is rendered, the template
+// asks for it by selecting an engine. Other manifests are left to Argo, which
+// substitutes its own {{...}} expressions, the values annotations of the
+// workflow only apply to the templates asking for rendering.
+func renderRequested(tmpl *argoworkflowv1alpha1.Template) bool {
+	_, ok := tmpl.Metadata.Annotations[common.TemplateEngineAnnotation]
+	return ok
+}
 
-	m["driver"] = core
+// process renders the manifest of the named template with the named engine, a
+// value referenced by the manifest but missing from values is reported as an error
+func (a *ArgoWorkflowHandler) process(name, engineName, manifest string, values map[string]interface{}) (string, error) {
+	e, err := engine.Get(engineName)
+	if err != nil {
+		return "", &TemplateError{Template: name, Message: err.Error()}
+	}
 
-	tmpl, _ := template.New("test").Parse(manifest)
-	_ = tmpl.Execute(&buf, m)
+	rendered, err := e.Render(name, manifest, values)
+	if err != nil {
+		return "", newTemplateError(name, err)
+	}
 
-	return buf.String()
+	return rendered, nil
 }
 
 // podAnnotator implements admission.DecoderInjector.
@@ -82,53 +204,3 @@ func (a *ArgoWorkflowHandler) InjectDecoder(d *admission.Decoder) error {
 	a.decoder = d
 	return nil
 }
-
-func test() {
-	chartPath := "/mypath"
-	namespace := "default"
-	releaseName := "myrelease"
-
-	settings := cli.New()
-
-	actionConfig := new(action.Configuration)
-	// You can pass an empty string instead of settings.Namespace() to list
-	// all namespaces
-	if err := actionConfig.Init(settings.RESTClientGetter(), namespace,
-		os.Getenv("HELM_DRIVER"), log.Printf); err != nil {
-		log.Printf("%+v", err)
-		os.Exit(1)
-	}
-
-	// define values
-	vals := map[string]interface{}{
-		"redis": map[string]interface{}{
-			"sentinel": map[string]interface{}{
-				"masterName": "BigMaster",
-				"pass":       "random",
-				"addr":       "localhost",
-				"port":       "26379",
-			},
-		},
-	}
-
-	// load chart from the path
-	chart, err := loader.Load(chartPath)
-	if err != nil {
-		panic(err)
-	}
-
-	client := action.NewInstall(actionConfig)
-	client.Namespace = namespace
-	client.ReleaseName = releaseName
-	// client.DryRun = true - very handy!
-
-	// install the chart here
-	rel, err := client.Run(chart, vals)
-	if err != nil {
-		panic(err)
-	}
-
-	log.Printf("Installed Chart from path: %s in namespace: %s\n", rel.Name, rel.Namespace)
-	// this will confirm the values set during installation
-	log.Println(rel.Config)
-}
@@ -0,0 +1,173 @@
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	argoworkflowv1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/allenhaozi/webhook/api/common"
+	"github.com/allenhaozi/webhook/pkg/engine"
+)
+
+func TestAdmitRenderOptIn(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = AddToScheme(scheme)
+
+	a := &ArgoWorkflowHandler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Log:    logr.Discard(),
+	}
+	argoManifest := "kind: ConfigMap\nname: {{inputs.parameters.x}}-{{workflow.name}}\ntrimmed: '{{=sprig.trim(inputs.parameters.x)}}'\n"
+
+	for _, tc := range []struct {
+		name        string
+		annotations map[string]string
+		engine      string
+		manifest    string
+		expected    string
+		denied      bool
+	}{
+		{
+			name:     "argo expressions pass through",
+			manifest: argoManifest,
+		},
+		{
+			name:        "values annotation without engine",
+			annotations: map[string]string{common.ValuesAnnotation: "name: pi"},
+			manifest:    argoManifest,
+		},
+		{
+			name:        "values annotation",
+			annotations: map[string]string{common.ValuesAnnotation: "name: pi"},
+			engine:      engine.Go,
+			manifest:    "name: {{ .name }}\n",
+			expected:    "name: pi\n",
+		},
+		{
+			name:     "engine annotation",
+			engine:   "sprig",
+			manifest: "name: {{ .x | default \"pi\" }}\n",
+			expected: "name: pi\n",
+		},
+		{
+			name:     "argo expressions rendered on request",
+			engine:   engine.Go,
+			manifest: argoManifest,
+			denied:   true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl := argoworkflowv1alpha1.Template{
+				Name:     "config",
+				Resource: &argoworkflowv1alpha1.ResourceTemplate{Manifest: tc.manifest},
+			}
+			if tc.engine != "" {
+				tmpl.Metadata.Annotations = map[string]string{common.TemplateEngineAnnotation: tc.engine}
+			}
+			// the other templates of the workflow are left to Argo
+			argoTmpl := argoworkflowv1alpha1.Template{
+				Name:     "argo",
+				Resource: &argoworkflowv1alpha1.ResourceTemplate{Manifest: argoManifest},
+			}
+			workflow := &argoworkflowv1alpha1.Workflow{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "pi", Annotations: tc.annotations},
+				Spec: argoworkflowv1alpha1.WorkflowSpec{
+					Arguments: argoworkflowv1alpha1.Arguments{Parameters: []argoworkflowv1alpha1.Parameter{
+						{Name: "x", Value: argoworkflowv1alpha1.AnyStringPtr("pi")},
+					}},
+					Templates: []argoworkflowv1alpha1.Template{tmpl, argoTmpl},
+				},
+			}
+			req := admission.Request{}
+			req.Namespace = "team"
+
+			resp := a.admit(context.Background(), req, workflow, &workflow.Spec, workflow.Spec.Arguments, "/spec")
+			if resp.Allowed == tc.denied {
+				t.Fatalf("expected denied %v, got %v", tc.denied, resp.Result)
+			}
+			if tc.denied {
+				return
+			}
+			if tc.expected == "" {
+				if len(resp.Patches) != 0 {
+					t.Errorf("expected the manifest to be left unchanged, got %v", resp.Patches)
+				}
+				return
+			}
+			if len(resp.Patches) != 1 || resp.Patches[0].Value != tc.expected {
+				t.Errorf("expected the manifest %q, got %v", tc.expected, resp.Patches)
+			}
+		})
+	}
+}
+
+func TestAdmitResolveValues(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = AddToScheme(scheme)
+
+	a := &ArgoWorkflowHandler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Log:    logr.Discard(),
+	}
+	// the values-from configmap is gone
+	workflow := &argoworkflowv1alpha1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "pi", Annotations: map[string]string{common.ValuesFromAnnotation: "deleted"}},
+		Spec: argoworkflowv1alpha1.WorkflowSpec{Templates: []argoworkflowv1alpha1.Template{
+			{Name: "main", Container: &corev1.Container{Image: "spark:3.3", Args: []string{"{{workflow.name}}"}}},
+		}},
+	}
+	req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Namespace: "team", Operation: admissionv1.Create}}
+
+	// no manifest renders, the values are not needed
+	if resp := a.admit(context.Background(), req, workflow, &workflow.Spec, workflow.Spec.Arguments, "/spec"); !resp.Allowed {
+		t.Fatalf("expected a workflow rendering no manifest to be admitted, got %v", resp.Result)
+	}
+
+	workflow.Spec.Templates = append(workflow.Spec.Templates, argoworkflowv1alpha1.Template{
+		Name:     "config",
+		Metadata: argoworkflowv1alpha1.Metadata{Annotations: map[string]string{common.TemplateEngineAnnotation: engine.Go}},
+		Resource: &argoworkflowv1alpha1.ResourceTemplate{Manifest: "name: {{ .name }}\n"},
+	})
+	if resp := a.admit(context.Background(), req, workflow, &workflow.Spec, workflow.Spec.Arguments, "/spec"); resp.Allowed {
+		t.Fatal("expected the missing values to deny a rendered manifest")
+	}
+
+	// an update of the status leaves the spec as rendered
+	old, err := json.Marshal(workflow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	workflow.Status.Phase = argoworkflowv1alpha1.WorkflowRunning
+	raw, err := json.Marshal(workflow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Operation = admissionv1.Update
+	req.Object.Raw = raw
+	req.OldObject.Raw = old
+	resp := a.admit(context.Background(), req, workflow, &workflow.Spec, workflow.Spec.Arguments, "/spec")
+	if !resp.Allowed || len(resp.Patches) != 0 {
+		t.Fatalf("expected a status update to be admitted as is, got %v %v", resp.Result, resp.Patches)
+	}
+
+	// a changed spec renders again
+	workflow.Spec.Templates[1].Resource.Manifest = "name: {{ .name }}-{{ .queue }}\n"
+	if req.Object.Raw, err = json.Marshal(workflow); err != nil {
+		t.Fatal(err)
+	}
+	if resp := a.admit(context.Background(), req, workflow, &workflow.Spec, workflow.Spec.Arguments, "/spec"); resp.Allowed {
+		t.Fatal("expected a changed spec to be rendered")
+	}
+}
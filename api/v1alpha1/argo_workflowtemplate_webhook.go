@@ -0,0 +1,73 @@
+package v1alpha1
+
+import (
+	"context"
+	"net/http"
+
+	argoworkflowv1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// WorkflowTemplateHandler renders the resource templates of workflow templates,
+// it shares the rendering of ArgoWorkflowHandler.
+//
+// A workflow template is rendered when it is stored, before any workflow
+// overrides its arguments, so its manifests are rendered from the values
+// annotations and the ManifestRenderPolicies only, spec.arguments are left out:
+// a manifest referencing a parameter is denied, parameters are substituted by
+// Argo through {{inputs.parameters.name}} expressions.
+type WorkflowTemplateHandler struct {
+	ArgoWorkflowHandler
+}
+
+//+kubebuilder:webhook:path=/mutate-v1alpha1-argoworkflowtemplate,mutating=true,failurePolicy=fail,sideEffects=None,groups=argoproj.io,resources=workflowtemplates,verbs=create;update,versions=v1alpha1,name=mworkflowtemplate.argoproj.io,admissionReviewVersions=v1
+
+// Handle renders the manifests of the resource templates of an incoming workflow template.
+func (a *WorkflowTemplateHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	wftmpl := &argoworkflowv1alpha1.WorkflowTemplate{}
+
+	if err := a.decoder.Decode(req, wftmpl); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	return a.admit(ctx, req, wftmpl, &wftmpl.Spec, argoworkflowv1alpha1.Arguments{}, "/spec")
+}
+
+// ClusterWorkflowTemplateHandler renders the resource templates of cluster workflow templates,
+// being cluster scoped they can not take values from a ConfigMap. As those of
+// workflow templates, their manifests are rendered without spec.arguments.
+type ClusterWorkflowTemplateHandler struct {
+	ArgoWorkflowHandler
+}
+
+//+kubebuilder:webhook:path=/mutate-v1alpha1-argoclusterworkflowtemplate,mutating=true,failurePolicy=fail,sideEffects=None,groups=argoproj.io,resources=clusterworkflowtemplates,verbs=create;update,versions=v1alpha1,name=mclusterworkflowtemplate.argoproj.io,admissionReviewVersions=v1
+
+// Handle renders the manifests of the resource templates of an incoming cluster workflow template.
+func (a *ClusterWorkflowTemplateHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	cwftmpl := &argoworkflowv1alpha1.ClusterWorkflowTemplate{}
+
+	if err := a.decoder.Decode(req, cwftmpl); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	return a.admit(ctx, req, cwftmpl, &cwftmpl.Spec, argoworkflowv1alpha1.Arguments{}, "/spec")
+}
+
+// CronWorkflowHandler renders the resource templates of the workflow spec of cron workflows,
+// the arguments of the spec are those of every workflow it starts
+type CronWorkflowHandler struct {
+	ArgoWorkflowHandler
+}
+
+//+kubebuilder:webhook:path=/mutate-v1alpha1-argocronworkflow,mutating=true,failurePolicy=fail,sideEffects=None,groups=argoproj.io,resources=cronworkflows,verbs=create;update,versions=v1alpha1,name=mcronworkflow.argoproj.io,admissionReviewVersions=v1
+
+// Handle renders the manifests of the resource templates of an incoming cron workflow.
+func (a *CronWorkflowHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	cronwf := &argoworkflowv1alpha1.CronWorkflow{}
+
+	if err := a.decoder.Decode(req, cronwf); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	return a.admit(ctx, req, cronwf, &cronwf.Spec.WorkflowSpec, cronwf.Spec.WorkflowSpec.Arguments, "/spec/workflowSpec")
+}
@@ -0,0 +1,142 @@
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	argoworkflowv1alpha1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/allenhaozi/webhook/api/common"
+	"github.com/allenhaozi/webhook/pkg/engine"
+)
+
+func TestWorkflowKindHandlers(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = AddToScheme(scheme)
+
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	argoHandler := ArgoWorkflowHandler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Log:    logr.Discard(),
+	}
+	if err := argoHandler.InjectDecoder(decoder); err != nil {
+		t.Fatal(err)
+	}
+
+	objectMeta := metav1.ObjectMeta{
+		Name:        "pi",
+		Annotations: map[string]string{common.ValuesAnnotation: "registry: r.io"},
+	}
+	spec := func(manifest string) argoworkflowv1alpha1.WorkflowSpec {
+		return argoworkflowv1alpha1.WorkflowSpec{
+			Arguments: argoworkflowv1alpha1.Arguments{Parameters: []argoworkflowv1alpha1.Parameter{
+				{Name: "image", Default: argoworkflowv1alpha1.AnyStringPtr("spark:3.3")},
+			}},
+			Templates: []argoworkflowv1alpha1.Template{
+				{Name: "main", Steps: []argoworkflowv1alpha1.ParallelSteps{}},
+				{
+					Name:     "spark",
+					Metadata: argoworkflowv1alpha1.Metadata{Annotations: map[string]string{common.TemplateEngineAnnotation: engine.Go}},
+					Resource: &argoworkflowv1alpha1.ResourceTemplate{Manifest: manifest},
+				},
+			},
+		}
+	}
+	// the manifests of workflow templates are rendered before any workflow sets
+	// the arguments, they may only reference the values annotations
+	withValues := "image: {{ .registry }}/spark\n"
+	withArguments := "image: {{ .registry }}/{{ .image }}\n"
+
+	for _, tc := range []struct {
+		name      string
+		handler   admission.Handler
+		namespace string
+		obj       runtime.Object
+		path      string
+		expected  string
+	}{
+		{
+			name:      "workflow",
+			handler:   &argoHandler,
+			namespace: "team",
+			obj:       &argoworkflowv1alpha1.Workflow{ObjectMeta: objectMeta, Spec: spec(withArguments)},
+			path:      "/spec/templates/1/resource/manifest",
+			expected:  "image: r.io/spark:3.3\n",
+		},
+		{
+			name:      "workflow template",
+			handler:   &WorkflowTemplateHandler{ArgoWorkflowHandler: argoHandler},
+			namespace: "team",
+			obj:       &argoworkflowv1alpha1.WorkflowTemplate{ObjectMeta: objectMeta, Spec: spec(withValues)},
+			path:      "/spec/templates/1/resource/manifest",
+			expected:  "image: r.io/spark\n",
+		},
+		{
+			name:      "workflow template referencing an argument",
+			handler:   &WorkflowTemplateHandler{ArgoWorkflowHandler: argoHandler},
+			namespace: "team",
+			obj:       &argoworkflowv1alpha1.WorkflowTemplate{ObjectMeta: objectMeta, Spec: spec(withArguments)},
+		},
+		{
+			name:     "cluster workflow template",
+			handler:  &ClusterWorkflowTemplateHandler{ArgoWorkflowHandler: argoHandler},
+			obj:      &argoworkflowv1alpha1.ClusterWorkflowTemplate{ObjectMeta: objectMeta, Spec: spec(withValues)},
+			path:     "/spec/templates/1/resource/manifest",
+			expected: "image: r.io/spark\n",
+		},
+		{
+			name:    "cluster workflow template referencing an argument",
+			handler: &ClusterWorkflowTemplateHandler{ArgoWorkflowHandler: argoHandler},
+			obj:     &argoworkflowv1alpha1.ClusterWorkflowTemplate{ObjectMeta: objectMeta, Spec: spec(withArguments)},
+		},
+		{
+			name:      "cron workflow",
+			handler:   &CronWorkflowHandler{ArgoWorkflowHandler: argoHandler},
+			namespace: "team",
+			obj: &argoworkflowv1alpha1.CronWorkflow{
+				ObjectMeta: objectMeta,
+				Spec:       argoworkflowv1alpha1.CronWorkflowSpec{Schedule: "0 * * * *", WorkflowSpec: spec(withArguments)},
+			},
+			path:     "/spec/workflowSpec/templates/1/resource/manifest",
+			expected: "image: r.io/spark:3.3\n",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := json.Marshal(tc.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Namespace: tc.namespace,
+				Operation: admissionv1.Create,
+				Object:    runtime.RawExtension{Raw: raw},
+			}}
+
+			resp := tc.handler.Handle(context.Background(), req)
+			if tc.expected == "" {
+				if resp.Allowed {
+					t.Fatalf("expected the missing argument to deny the object, got %v", resp.Patches)
+				}
+				return
+			}
+			if !resp.Allowed {
+				t.Fatalf("denied: %v", resp.Result)
+			}
+			if len(resp.Patches) != 1 || resp.Patches[0].Path != tc.path || resp.Patches[0].Value != tc.expected {
+				t.Errorf("expected %s to be %q, got %v", tc.path, tc.expected, resp.Patches)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ManifestRenderPolicySpec defines how the resource manifests of the selected
+// workflows, workflow templates and cron workflows are rendered
+type ManifestRenderPolicySpec struct {
+	// NamespaceSelector selects the namespaces of the workflows, only the
+	// namespace of the policy when unset. Policies select workflows of other
+	// namespaces only in the policy namespace of the webhook, see --policy-namespace
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// WorkflowSelector selects the workflows by label, every workflow of the
+	// selected namespaces when unset
+	// +optional
+	WorkflowSelector *metav1.LabelSelector `json:"workflowSelector,omitempty"`
+	// TargetKinds are the kinds of the resource manifests the values apply to,
+	// every kind when empty
+	// +optional
+	TargetKinds []string `json:"targetKinds,omitempty"`
+	// ValuesFrom are read in order, a later source overrides an earlier one.
+	// The sources are read from the namespace of the policy.
+	// +optional
+	ValuesFrom []ValuesSource `json:"valuesFrom,omitempty"`
+	// Values override ValuesFrom
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +optional
+	Values *apiextensionsv1.JSON `json:"values,omitempty"`
+}
+
+// ValuesSource is a YAML document of values held by a ConfigMap key. Secrets
+// are no values source: the rendered manifests are stored in plain text in the
+// workflow, a manifest references a Secret by name instead.
+type ValuesSource struct {
+	ConfigMapKeyRef *corev1.ConfigMapKeySelector `json:"configMapKeyRef"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:shortName=mrp
+//+kubebuilder:printcolumn:name="Target Kinds",type=string,JSONPath=`.spec.targetKinds`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ManifestRenderPolicy supplies the values ArgoWorkflowHandler renders the
+// resource manifests of the selected workflows with. Values of the policies
+// are merged by policy name and overridden by the values of the workflow.
+// A policy renders no template by itself, only the resource templates asking
+// for rendering with common.TemplateEngineAnnotation or common.ChartAnnotation
+// get its values, the other manifests are left to Argo.
+type ManifestRenderPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ManifestRenderPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ManifestRenderPolicyList contains a list of ManifestRenderPolicy
+type ManifestRenderPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ManifestRenderPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ManifestRenderPolicy{}, &ManifestRenderPolicyList{})
+}
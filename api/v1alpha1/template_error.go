@@ -0,0 +1,61 @@
+package v1alpha1
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/allenhaozi/webhook/pkg/engine"
+)
+
+// text/template reports failures as "template: <name>:<line>[:<column>]: <message>",
+// parse errors carry only the line, execution errors carry line and column
+var templatePositionPattern = regexp.MustCompile(`(?s)^(\d+)(?::(\d+))?: (.*)$`)
+
+// TemplateError describes a resource manifest template that failed to parse or execute
+type TemplateError struct {
+	// Template is the name of the Argo template holding the manifest
+	Template string
+	// Line and Column locate the failure in the manifest, zero when unknown
+	Line   int
+	Column int
+	// Message is the engine failure without its position prefix
+	Message string
+}
+
+func (e *TemplateError) Error() string {
+	switch {
+	case e.Line > 0 && e.Column > 0:
+		return fmt.Sprintf("template %q line %d column %d: %s", e.Template, e.Line, e.Column, e.Message)
+	case e.Line > 0:
+		return fmt.Sprintf("template %q line %d: %s", e.Template, e.Line, e.Message)
+	default:
+		return fmt.Sprintf("template %q: %s", e.Template, e.Message)
+	}
+}
+
+// newTemplateError converts an error returned by a template engine for the named template
+func newTemplateError(name string, err error) *TemplateError {
+	var engineErr *engine.Error
+	if errors.As(err, &engineErr) {
+		return &TemplateError{Template: name, Line: engineErr.Line, Column: engineErr.Column, Message: engineErr.Message}
+	}
+
+	e := &TemplateError{Template: name, Message: err.Error()}
+
+	rest := strings.TrimPrefix(err.Error(), "template: "+name+":")
+	m := templatePositionPattern.FindStringSubmatch(rest)
+	if m == nil {
+		return e
+	}
+
+	e.Line, _ = strconv.Atoi(m[1])
+	if m[2] != "" {
+		e.Column, _ = strconv.Atoi(m[2])
+	}
+	e.Message = m[3]
+
+	return e
+}
@@ -0,0 +1,35 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/go-logr/logr"
+)
+
+func TestProcessTemplateError(t *testing.T) {
+	a := &ArgoWorkflowHandler{Log: logr.Discard()}
+
+	cases := []struct {
+		engine   string
+		manifest string
+		line     int
+		column   int
+	}{
+		{manifest: "kind: SparkApplication\ncores: {{ .driver.cores }\n", line: 2},
+		{manifest: "kind: SparkApplication\ncores: {{ .driver.cores }}\n", line: 2, column: 17},
+		{engine: "jinja2", manifest: "kind: SparkApplication\n{% for e in [1] %}\ncores: {{ driver.cores }}\n{% endfor %}\n", line: 3, column: 8},
+		{engine: "jinja2", manifest: "kind: SparkApplication\ncores: {{ driver.cores }}\n", line: 2, column: 8},
+		{engine: "mustache", manifest: "kind: SparkApplication\n"},
+	}
+
+	for _, c := range cases {
+		_, err := a.process("pi-tmpl", c.engine, c.manifest, map[string]interface{}{})
+		tmplErr, ok := err.(*TemplateError)
+		if !ok {
+			t.Fatalf("expected *TemplateError, got %v", err)
+		}
+		if tmplErr.Template != "pi-tmpl" || tmplErr.Line != c.line || tmplErr.Column != c.column {
+			t.Errorf("unexpected error position: %v", tmplErr)
+		}
+	}
+}
@@ -1,9 +1,33 @@
 package main
 
-import "github.com/allenhaozi/webhook/pkg/helm"
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
 
+	"github.com/allenhaozi/webhook/pkg/helm"
+)
+
+// renders a chart the way the webhook does and prints its manifests
 func main() {
-	chartPath := "/Users/mahao/go/src/github.com/allenhaozi/webhook/charts/salesforecast"
-	valueFile := "/Users/mahao/go/src/github.com/allenhaozi/webhook/charts/salesforecast/values.yaml"
-	helm.Template(chartPath, valueFile)
+	var chartPath, valueFile string
+	flag.StringVar(&chartPath, "chart", "charts/salesforecast", "The chart to render.")
+	flag.StringVar(&valueFile, "values", "", "An optional values file merged over the chart defaults.")
+	flag.Parse()
+
+	vals := helm.Values{}
+	if valueFile != "" {
+		vals.Files = append(vals.Files, valueFile)
+	}
+
+	manifests, err := helm.Render(context.Background(), helm.ChartSource{Path: chartPath}, vals, helm.ReleaseOptions{})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	for _, m := range manifests {
+		fmt.Printf("---\n%s\n", m.Content)
+	}
 }
@@ -0,0 +1,100 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// APIServiceReconciler injects the CA bundle into an APIService, the
+// kube-aggregator types aren't a dependency so APIServices are unstructured
+type APIServiceReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Certs  CertSource
+	Log    logr.Logger
+	// Selector picks the APIServices the CA bundle is injected into
+	Selector InjectionSelector
+}
+
+// +kubebuilder:rbac:groups=apiregistration.k8s.io,resources=apiservices,verbs=get;list;watch;update;patch
+
+func (r *APIServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	a := newAPIService()
+	if err := r.Get(ctx, req.NamespacedName, a); err != nil {
+		// a deleted object has no caBundle left to inject
+		if err = client.IgnoreNotFound(err); err != nil {
+			r.Log.Error(err, "fail to get apiService", "name", req.NamespacedName)
+		}
+		return ctrl.Result{}, err
+	}
+	// the APIService may have been deselected after the request was queued
+	if !r.Selector.Matches(a) {
+		return ctrl.Result{}, nil
+	}
+
+	r.Log.Info("received apiservice data", "APIService", req.NamespacedName)
+
+	if err := patchCaBundle(ctx, r.Client, r.Log, a, r.Certs.CertContext().CABundle); err != nil {
+		r.Log.Error(err, "fail to patch CABundle to apiService", "name", req.NamespacedName)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// apiServicesForCertChange maps a certificate change to every selected APIService
+func (r *APIServiceReconciler) apiServicesForCertChange(_ client.Object) []reconcile.Request {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(APIServiceGVK.GroupVersion().WithKind(APIServiceGVK.Kind + "List"))
+	return requestsForSelected(context.Background(), r.Client, r.Log, list, r.Selector)
+}
+
+func newAPIService() *unstructured.Unstructured {
+	a := &unstructured.Unstructured{}
+	a.SetGroupVersionKind(APIServiceGVK)
+	return a
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *APIServiceReconciler) SetupWithManager(mgr ctrl.Manager, l logr.Logger, certs CertSource, selector InjectionSelector) error {
+	rec := NewAPIServiceReconciler(mgr, l, certs, selector)
+	return ctrl.NewControllerManagedBy(mgr).
+		For(newAPIService(), builder.WithPredicates(selector.Predicate())).
+		// inject the CA bundle again whenever the certificate is rotated
+		Watches(&source.Channel{Source: certs.Subscribe()}, handler.EnqueueRequestsFromMapFunc(rec.apiServicesForCertChange)).
+		Complete(rec)
+}
+
+func NewAPIServiceReconciler(mgr ctrl.Manager, l logr.Logger, certs CertSource, selector InjectionSelector) *APIServiceReconciler {
+	r := &APIServiceReconciler{}
+	r.Client = mgr.GetClient()
+	r.Log = l
+	r.Certs = certs
+	r.Selector = selector
+	return r
+}
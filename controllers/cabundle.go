@@ -0,0 +1,163 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/allenhaozi/webhook/api/common"
+)
+
+// APIServiceGVK is the kind of the aggregated API registrations, handled as
+// unstructured objects
+var APIServiceGVK = schema.GroupVersionKind{Group: "apiregistration.k8s.io", Version: "v1", Kind: "APIService"}
+
+// CertSource provides the current webhook certificate and signals its changes
+type CertSource interface {
+	CertContext() *common.CertContext
+	Subscribe() <-chan event.GenericEvent
+}
+
+// patchCaBundle sets caBundle on every client config of a webhook configuration,
+// a CRD conversion webhook or an APIService and patches the object when any changed
+func patchCaBundle(ctx context.Context, c client.Client, l logr.Logger, obj client.Object, caBundle []byte) error {
+	current := obj.DeepCopyObject().(client.Object)
+
+	changed, err := injectCaBundle(obj, caBundle)
+	if err != nil {
+		return err
+	}
+	if !changed {
+		l.Info("no need to patch the caBundle", "name", obj.GetName())
+		return nil
+	}
+
+	if err := c.Patch(ctx, obj, client.MergeFrom(current)); err != nil {
+		// the object may have been deleted since it was read
+		if err = client.IgnoreNotFound(err); err != nil {
+			l.Error(err, "fail to patch caBundle", "name", obj.GetName())
+		}
+		return err
+	}
+
+	l.Info("finished patch caBundle", "name", obj.GetName())
+
+	return nil
+}
+
+// injectCaBundle sets caBundle on obj in place and tells whether it changed
+func injectCaBundle(obj client.Object, caBundle []byte) (bool, error) {
+	changed := false
+	set := func(dst *[]byte) {
+		if !bytes.Equal(*dst, caBundle) {
+			*dst = caBundle
+			changed = true
+		}
+	}
+
+	switch o := obj.(type) {
+	case *admissionv1.MutatingWebhookConfiguration:
+		for i := range o.Webhooks {
+			set(&o.Webhooks[i].ClientConfig.CABundle)
+		}
+	case *admissionv1.ValidatingWebhookConfiguration:
+		for i := range o.Webhooks {
+			set(&o.Webhooks[i].ClientConfig.CABundle)
+		}
+	case *apiextensionsv1.CustomResourceDefinition:
+		// only a conversion webhook calls back into a service
+		conversion := o.Spec.Conversion
+		if conversion == nil || conversion.Strategy != apiextensionsv1.WebhookConverter ||
+			conversion.Webhook == nil || conversion.Webhook.ClientConfig == nil {
+			return false, nil
+		}
+		set(&conversion.Webhook.ClientConfig.CABundle)
+	case *unstructured.Unstructured:
+		if o.GroupVersionKind() != APIServiceGVK {
+			return false, errors.Errorf("can't inject caBundle into %s", o.GroupVersionKind())
+		}
+		// the API server rejects a caBundle on an APIService skipping TLS verification
+		if skip, _, _ := unstructured.NestedBool(o.Object, "spec", "insecureSkipTLSVerify"); skip {
+			return false, nil
+		}
+		encoded, _, _ := unstructured.NestedString(o.Object, "spec", "caBundle")
+		if encoded == base64.StdEncoding.EncodeToString(caBundle) {
+			return false, nil
+		}
+		if err := unstructured.SetNestedField(o.Object, base64.StdEncoding.EncodeToString(caBundle), "spec", "caBundle"); err != nil {
+			return false, err
+		}
+		changed = true
+	default:
+		return false, errors.Errorf("can't inject caBundle into %T", obj)
+	}
+
+	return changed, nil
+}
+
+// requestsForSelected lists the objects of the list type and returns a request
+// for every selected one
+func requestsForSelected(ctx context.Context, c client.Client, l logr.Logger, list client.ObjectList, selector InjectionSelector) []reconcile.Request {
+	if err := c.List(ctx, list); err != nil {
+		l.Error(err, "fail to list objects to inject caBundle")
+		return nil
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		l.Error(err, "fail to extract objects to inject caBundle")
+		return nil
+	}
+
+	requests := []reconcile.Request{}
+	for _, item := range items {
+		if obj, ok := item.(client.Object); ok && selector.Matches(obj) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(obj)})
+		}
+	}
+	return requests
+}
+
+// InjectionSelector selects the objects the CA bundle is injected into, an object
+// is selected when it names the certificate secret in common.InjectCAAnnotation,
+// either as "<namespace>/<name>" or as "<name>" in the namespace of the secret,
+// or when its name is listed in Names
+type InjectionSelector struct {
+	Secret types.NamespacedName
+	Names  sets.String
+}
+
+func (s InjectionSelector) Matches(obj client.Object) bool {
+	if s.Names.Has(obj.GetName()) {
+		return true
+	}
+
+	v, ok := obj.GetAnnotations()[common.InjectCAAnnotation]
+	if !ok {
+		return false
+	}
+	namespace, name := s.Secret.Namespace, v
+	if i := strings.Index(v, "/"); i >= 0 {
+		namespace, name = v[:i], v[i+1:]
+	}
+	return namespace == s.Secret.Namespace && name == s.Secret.Name
+}
+
+// Predicate filters the events of the selected objects
+func (s InjectionSelector) Predicate() predicate.Predicate {
+	return predicate.NewPredicateFuncs(s.Matches)
+}
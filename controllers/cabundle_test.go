@@ -0,0 +1,159 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/allenhaozi/webhook/api/common"
+)
+
+type staticCerts struct {
+	certContext *common.CertContext
+}
+
+func (s *staticCerts) CertContext() *common.CertContext {
+	return s.certContext
+}
+
+func (s *staticCerts) Subscribe() <-chan event.GenericEvent {
+	return make(chan event.GenericEvent)
+}
+
+func TestWebhookConfigurationCaBundle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := admissionv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	objectMeta := k8smetav1.ObjectMeta{
+		Name:        "webhook-configuration",
+		Annotations: map[string]string{common.InjectCAAnnotation: common.WebHookName},
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&admissionv1.MutatingWebhookConfiguration{
+			ObjectMeta: objectMeta,
+			Webhooks:   []admissionv1.MutatingWebhook{{Name: "mworkflow.argoproj.io"}, {Name: "mmetawebhook.kb.io"}},
+		},
+		&admissionv1.ValidatingWebhookConfiguration{
+			ObjectMeta: objectMeta,
+			Webhooks:   []admissionv1.ValidatingWebhook{{Name: "vmetawebhook.kb.io"}},
+		},
+	).Build()
+	certs := &staticCerts{certContext: &common.CertContext{CABundle: []byte("ca bundle")}}
+	selector := InjectionSelector{Secret: types.NamespacedName{Namespace: "default", Name: common.WebHookName}, Names: sets.NewString()}
+
+	reconcilers := []interface {
+		Reconcile(context.Context, ctrl.Request) (ctrl.Result, error)
+	}{
+		&MutatingWebhookConfigurationReconciler{Client: c, Certs: certs, Log: logr.Discard(), Selector: selector},
+		&ValidatingWebhookConfigurationReconciler{Client: c, Certs: certs, Log: logr.Discard(), Selector: selector},
+	}
+	for _, r := range reconcilers {
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "webhook-configuration"}}); err != nil {
+			t.Fatal(err)
+		}
+		// deleted configurations are not an error
+		if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "deleted"}}); err != nil {
+			t.Errorf("%T: expected NotFound to be ignored, got %v", r, err)
+		}
+	}
+
+	for _, obj := range []client.Object{&admissionv1.MutatingWebhookConfiguration{}, &admissionv1.ValidatingWebhookConfiguration{}} {
+		if err := c.Get(context.Background(), types.NamespacedName{Name: "webhook-configuration"}, obj); err != nil {
+			t.Fatal(err)
+		}
+		if changed, err := injectCaBundle(obj, []byte("ca bundle")); err != nil || changed {
+			t.Errorf("%T: caBundle not injected", obj)
+		}
+	}
+
+	// a configuration deleted after it was read is not an error either
+	deleted := &admissionv1.MutatingWebhookConfiguration{
+		ObjectMeta: k8smetav1.ObjectMeta{Name: "deleted"},
+		Webhooks:   []admissionv1.MutatingWebhook{{Name: "mworkflow.argoproj.io"}},
+	}
+	if err := patchCaBundle(context.Background(), c, logr.Discard(), deleted, []byte("ca bundle")); err != nil {
+		t.Errorf("expected NotFound to be ignored, got %v", err)
+	}
+}
+
+func TestInjectCaBundle(t *testing.T) {
+	caBundle := []byte("ca bundle")
+
+	crd := &apiextensionsv1.CustomResourceDefinition{
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Conversion: &apiextensionsv1.CustomResourceConversion{
+				Strategy: apiextensionsv1.WebhookConverter,
+				Webhook: &apiextensionsv1.WebhookConversion{
+					ClientConfig: &apiextensionsv1.WebhookClientConfig{},
+				},
+			},
+		},
+	}
+	if changed, err := injectCaBundle(crd, caBundle); err != nil || !changed {
+		t.Fatalf("CRD: expected a change, got %v %v", changed, err)
+	}
+	if !bytes.Equal(crd.Spec.Conversion.Webhook.ClientConfig.CABundle, caBundle) {
+		t.Error("CRD: caBundle not injected")
+	}
+
+	// a CRD without conversion webhook is left alone
+	if changed, err := injectCaBundle(&apiextensionsv1.CustomResourceDefinition{}, caBundle); err != nil || changed {
+		t.Errorf("CRD without webhook: unexpected change %v %v", changed, err)
+	}
+
+	apiService := newAPIService()
+	apiService.Object["spec"] = map[string]interface{}{"group": "metrics.k8s.io"}
+	if changed, err := injectCaBundle(apiService, caBundle); err != nil || !changed {
+		t.Fatalf("APIService: expected a change, got %v %v", changed, err)
+	}
+	if v, _, _ := unstructured.NestedString(apiService.Object, "spec", "caBundle"); v != base64.StdEncoding.EncodeToString(caBundle) {
+		t.Errorf("APIService: unexpected caBundle %q", v)
+	}
+	if changed, _ := injectCaBundle(apiService, caBundle); changed {
+		t.Error("APIService: unchanged caBundle reported as a change")
+	}
+}
+
+func TestInjectionSelector(t *testing.T) {
+	s := InjectionSelector{
+		Secret: types.NamespacedName{Namespace: "default", Name: common.WebHookName},
+		Names:  sets.NewString("listed"),
+	}
+
+	for _, tc := range []struct {
+		name       string
+		annotation string
+		matches    bool
+	}{
+		{name: "listed", matches: true},
+		{name: "unlisted"},
+		{name: "annotated", annotation: common.WebHookName, matches: true},
+		{name: "namespaced", annotation: "default/" + common.WebHookName, matches: true},
+		{name: "other-namespace", annotation: "system/" + common.WebHookName},
+		{name: "other-secret", annotation: "other"},
+	} {
+		obj := &admissionv1.MutatingWebhookConfiguration{ObjectMeta: k8smetav1.ObjectMeta{Name: tc.name}}
+		if tc.annotation != "" {
+			obj.Annotations = map[string]string{common.InjectCAAnnotation: tc.annotation}
+		}
+		if got := s.Matches(obj); got != tc.matches {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.matches, got)
+		}
+	}
+}
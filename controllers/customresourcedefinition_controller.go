@@ -0,0 +1,91 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// CustomResourceDefinitionReconciler injects the CA bundle into the conversion webhook of a CustomResourceDefinition
+type CustomResourceDefinitionReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Certs  CertSource
+	Log    logr.Logger
+	// Selector picks the CustomResourceDefinitions the CA bundle is injected into
+	Selector InjectionSelector
+}
+
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch;update;patch
+
+func (r *CustomResourceDefinitionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var crd apiextensionsv1.CustomResourceDefinition
+	if err := r.Get(ctx, req.NamespacedName, &crd); err != nil {
+		// a deleted object has no caBundle left to inject
+		if err = client.IgnoreNotFound(err); err != nil {
+			r.Log.Error(err, "fail to get customResourceDefinition", "name", req.NamespacedName)
+		}
+		return ctrl.Result{}, err
+	}
+	// the definition may have been deselected after the request was queued
+	if !r.Selector.Matches(&crd) {
+		return ctrl.Result{}, nil
+	}
+
+	r.Log.Info("received customresourcedefinition data", "CustomResourceDefinition", req.NamespacedName)
+
+	if err := patchCaBundle(ctx, r.Client, r.Log, &crd, r.Certs.CertContext().CABundle); err != nil {
+		r.Log.Error(err, "fail to patch CABundle to customResourceDefinition", "name", req.NamespacedName)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// definitionsForCertChange maps a certificate change to every selected CustomResourceDefinition
+func (r *CustomResourceDefinitionReconciler) definitionsForCertChange(_ client.Object) []reconcile.Request {
+	return requestsForSelected(context.Background(), r.Client, r.Log, &apiextensionsv1.CustomResourceDefinitionList{}, r.Selector)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *CustomResourceDefinitionReconciler) SetupWithManager(mgr ctrl.Manager, l logr.Logger, certs CertSource, selector InjectionSelector) error {
+	rec := NewCustomResourceDefinitionReconciler(mgr, l, certs, selector)
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&apiextensionsv1.CustomResourceDefinition{}, builder.WithPredicates(selector.Predicate())).
+		// inject the CA bundle again whenever the certificate is rotated
+		Watches(&source.Channel{Source: certs.Subscribe()}, handler.EnqueueRequestsFromMapFunc(rec.definitionsForCertChange)).
+		Complete(rec)
+}
+
+func NewCustomResourceDefinitionReconciler(mgr ctrl.Manager, l logr.Logger, certs CertSource, selector InjectionSelector) *CustomResourceDefinitionReconciler {
+	r := &CustomResourceDefinitionReconciler{}
+	r.Client = mgr.GetClient()
+	r.Log = l
+	r.Certs = certs
+	r.Selector = selector
+	return r
+}
@@ -18,59 +18,167 @@ package controllers
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	metav1 "github.com/allenhaozi/webhook/api/v1"
+	"github.com/allenhaozi/webhook/pkg/catalog"
+)
+
+// reasons of the MetaWebHook conditions
+const (
+	reasonTableSynced     = "TableSynced"
+	reasonTableNotFound   = "TableNotFound"
+	reasonCatalogError    = "CatalogError"
+	reasonCatalogDisabled = "CatalogDisabled"
+	reasonTableKept       = "TableKept"
 )
 
 // MetaWebHookReconciler reconciles a MetaWebHook object
 type MetaWebHookReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
-	Log    logr.Logger
+	Scheme  *runtime.Scheme
+	Log     logr.Logger
+	Catalog catalog.Client
+	// ResyncPeriod is how often the table metadata is synced again from the catalog
+	ResyncPeriod time.Duration
 }
 
 //+kubebuilder:rbac:groups=meta.github.com,resources=metawebhooks,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=meta.github.com,resources=metawebhooks/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=meta.github.com,resources=metawebhooks/finalizers,verbs=update
 
-// Reconcile is part of the main kubernetes reconciliation loop which aims to
-// move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the MetaWebHook object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// Reconcile syncs the metadata of the table a MetaWebHook refers to from the
+// catalog into its status, and requeues itself to resync every ResyncPeriod.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.13.0/pkg/reconcile
 func (r *MetaWebHookReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	var webhook metav1.MetaWebHook
 	if err := r.Get(ctx, req.NamespacedName, &webhook); err != nil {
-		r.Log.Error(err, "got error")
+		// deleted objects have nothing left to sync
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	r.Log.Info("received webhook data", "webhook", req.NamespacedName)
+
+	current := webhook.DeepCopy()
+	syncErr := r.syncTable(ctx, &webhook)
+	webhook.Status.ObservedGeneration = webhook.Generation
+
+	if err := r.Status().Patch(ctx, &webhook, client.MergeFrom(current)); err != nil {
+		r.Log.Error(err, "fail to update MetaWebHook status", "webhook", req.NamespacedName)
 		return ctrl.Result{}, err
 	}
 
-	r.Log.Info("received webhook data", "webhook", webhook)
+	// catalog errors are retried with backoff, anything else waits for the next resync
+	if syncErr != nil {
+		return ctrl.Result{}, syncErr
+	}
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
 
-	return ctrl.Result{}, nil
+// syncTable looks the table up in the catalog and records the result in the
+// status conditions, only unexpected catalog errors are returned
+func (r *MetaWebHookReconciler) syncTable(ctx context.Context, webhook *metav1.MetaWebHook) error {
+	if r.Catalog == nil {
+		r.setConditions(webhook, k8smetav1.ConditionFalse, reasonCatalogDisabled, "no catalog configured")
+		return nil
+	}
+
+	var table *catalog.Table
+	var err error
+	if webhook.Spec.TableId != "" {
+		table, err = r.Catalog.GetTableByID(ctx, webhook.Spec.TableId)
+	} else {
+		table, err = r.Catalog.GetTableByFQN(ctx, webhook.Spec.TableFQN)
+	}
+
+	switch {
+	case catalog.IsNotFound(err):
+		webhook.Status.Table = nil
+		webhook.Status.LastSyncTime = nil
+		r.setConditions(webhook, k8smetav1.ConditionFalse, reasonTableNotFound, err.Error())
+		return nil
+	case err != nil:
+		// the metadata synced for the current spec is kept while the catalog is unreachable
+		if webhook.Status.ObservedGeneration != webhook.Generation {
+			webhook.Status.Table = nil
+			webhook.Status.LastSyncTime = nil
+		}
+		r.setConditions(webhook, k8smetav1.ConditionFalse, reasonCatalogError, err.Error())
+		return err
+	}
+
+	synced := &metav1.TableMetadata{
+		Name:               table.Name,
+		FullyQualifiedName: table.FullyQualifiedName,
+		Path:               table.Path,
+		Warehouse:          table.Warehouse,
+	}
+	for _, col := range table.Columns {
+		synced.Columns = append(synced.Columns, metav1.TableColumn{Name: col.Name, DataType: col.DataType})
+	}
+	now := k8smetav1.Now()
+	webhook.Status.Table = synced
+	webhook.Status.LastSyncTime = &now
+
+	r.setConditions(webhook, k8smetav1.ConditionTrue, reasonTableSynced, "table metadata synced from catalog")
+	return nil
+}
+
+// setConditions sets CatalogResolved to the outcome of the catalog lookup and
+// Ready to whether the status holds the table metadata of the current spec
+func (r *MetaWebHookReconciler) setConditions(webhook *metav1.MetaWebHook, status k8smetav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&webhook.Status.Conditions, k8smetav1.Condition{
+		Type:               metav1.ConditionCatalogResolved,
+		Status:             status,
+		ObservedGeneration: webhook.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+
+	ready := k8smetav1.ConditionFalse
+	if webhook.Status.Table != nil {
+		ready = k8smetav1.ConditionTrue
+		if status != k8smetav1.ConditionTrue {
+			reason = reasonTableKept
+			message = "table metadata of the last sync kept: " + message
+		}
+	}
+	meta.SetStatusCondition(&webhook.Status.Conditions, k8smetav1.Condition{
+		Type:               metav1.ConditionReady,
+		Status:             ready,
+		ObservedGeneration: webhook.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
 }
 
 // SetupWithManager sets up the controller with the Manager.
-func (r *MetaWebHookReconciler) SetupWithManager(mgr ctrl.Manager, l logr.Logger) error {
+func (r *MetaWebHookReconciler) SetupWithManager(mgr ctrl.Manager, l logr.Logger, catalogClient catalog.Client, resyncPeriod time.Duration) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&metav1.MetaWebHook{}).
+		// status updates must not trigger a sync, resyncs are driven by RequeueAfter
+		For(&metav1.MetaWebHook{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Complete(
-			NewMetaWebHookReconciler(mgr, l),
+			NewMetaWebHookReconciler(mgr, l, catalogClient, resyncPeriod),
 		)
 }
 
-func NewMetaWebHookReconciler(mrg ctrl.Manager, l logr.Logger) *MetaWebHookReconciler {
+func NewMetaWebHookReconciler(mrg ctrl.Manager, l logr.Logger, catalogClient catalog.Client, resyncPeriod time.Duration) *MetaWebHookReconciler {
 	r := &MetaWebHookReconciler{}
 	r.Log = l
 	r.Client = mrg.GetClient()
+	r.Scheme = mrg.GetScheme()
+	r.Catalog = catalogClient
+	r.ResyncPeriod = resyncPeriod
 	return r
 }
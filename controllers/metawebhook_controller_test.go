@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	metav1 "github.com/allenhaozi/webhook/api/v1"
+	"github.com/allenhaozi/webhook/pkg/catalog"
+)
+
+func TestMetaWebHookReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := metav1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	webhook := &metav1.MetaWebHook{
+		ObjectMeta: k8smetav1.ObjectMeta{Name: "naton", Namespace: "default", Generation: 2},
+		Spec:       metav1.MetaWebHookSpec{TableFQN: "lakehouse.salesforce.default.naton", TableId: "5c3b5d0e"},
+	}
+	r := &MetaWebHookReconciler{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(webhook).Build(),
+		Log:    logr.Discard(),
+		Catalog: catalog.NewFakeClient(catalog.Table{
+			ID:                 "5c3b5d0e",
+			Name:               "naton",
+			FullyQualifiedName: "lakehouse.salesforce.default.naton",
+			Columns:            []catalog.Column{{Name: "sku_id", DataType: "INT"}},
+		}),
+		ResyncPeriod: time.Minute,
+	}
+
+	key := types.NamespacedName{Name: "naton", Namespace: "default"}
+	result, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RequeueAfter != time.Minute {
+		t.Errorf("expected a resync after a minute, got %v", result)
+	}
+
+	got := &metav1.MetaWebHook{}
+	if err := r.Get(context.Background(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, metav1.ConditionReady) ||
+		got.Status.ObservedGeneration != 2 || got.Status.Table == nil || len(got.Status.Table.Columns) != 1 {
+		t.Errorf("unexpected status %+v", got.Status)
+	}
+
+	// the metadata is kept while the catalog is unreachable
+	r.Catalog = failingCatalog{}
+	key.Name = "naton"
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err == nil {
+		t.Error("expected the catalog error to be returned")
+	}
+	if err := r.Get(context.Background(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	if !meta.IsStatusConditionTrue(got.Status.Conditions, metav1.ConditionReady) ||
+		meta.IsStatusConditionTrue(got.Status.Conditions, metav1.ConditionCatalogResolved) || got.Status.Table == nil {
+		t.Errorf("expected the table metadata to be kept, got %+v", got.Status)
+	}
+
+	// a table gone from the catalog leaves no metadata behind
+	r.Catalog = catalog.NewFakeClient()
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(context.Background(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	if meta.IsStatusConditionTrue(got.Status.Conditions, metav1.ConditionReady) || got.Status.Table != nil || got.Status.LastSyncTime != nil {
+		t.Errorf("expected the table metadata to be cleared, got %+v", got.Status)
+	}
+
+	// deleted objects are not an error
+	key.Name = "deleted"
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Errorf("expected NotFound to be ignored, got %v", err)
+	}
+}
+
+// failingCatalog fails every lookup as an unreachable catalog does
+type failingCatalog struct{}
+
+func (failingCatalog) GetTableByFQN(context.Context, string) (*catalog.Table, error) {
+	return nil, errors.New("connection refused")
+}
+
+func (failingCatalog) GetTableByID(context.Context, string) (*catalog.Table, error) {
+	return nil, errors.New("connection refused")
+}
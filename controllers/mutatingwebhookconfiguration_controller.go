@@ -18,25 +18,26 @@ package controllers
 
 import (
 	"context"
-	"reflect"
 
 	"github.com/go-logr/logr"
 	admissionv1 "k8s.io/api/admissionregistration/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/event"
-	"sigs.k8s.io/controller-runtime/pkg/predicate"
-
-	"github.com/allenhaozi/webhook/api/common"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
-// MetaWebHookReconciler reconciles a MetaWebHook object
+// MutatingWebhookConfigurationReconciler injects the CA bundle into a MutatingWebhookConfiguration
 type MutatingWebhookConfigurationReconciler struct {
 	client.Client
-	Scheme      *runtime.Scheme
-	CertContext *common.CertContext
-	Log         logr.Logger
+	Scheme *runtime.Scheme
+	Certs  CertSource
+	Log    logr.Logger
+	// Selector picks the configurations the CA bundle is injected into
+	Selector InjectionSelector
 }
 
 // +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,verbs=get;list;watch;create;update;patch;delete
@@ -50,91 +51,49 @@ type MutatingWebhookConfigurationReconciler struct {
 func (r *MutatingWebhookConfigurationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	var m admissionv1.MutatingWebhookConfiguration
 	if err := r.Get(ctx, req.NamespacedName, &m); err != nil {
-		r.Log.Error(err, "got error")
+		// a deleted object has no caBundle left to inject
+		if err = client.IgnoreNotFound(err); err != nil {
+			r.Log.Error(err, "fail to get mutatingWebHookConfiguration", "name", req.NamespacedName)
+		}
 		return ctrl.Result{}, err
 	}
+	// the configuration may have been deselected after the request was queued
+	if !r.Selector.Matches(&m) {
+		return ctrl.Result{}, nil
+	}
 
 	r.Log.Info("received mutatingwebhookconfiguration data", "MutatingWebhookConfiguration", req.NamespacedName)
 
-	if err := r.patchCaBundle(&m); err != nil {
-		r.Log.Error(err, "fail to patch CABundle to mutatingWebHookConfiguration")
+	if err := patchCaBundle(ctx, r.Client, r.Log, &m, r.Certs.CertContext().CABundle); err != nil {
+		r.Log.Error(err, "fail to patch CABundle to mutatingWebHookConfiguration", "name", req.NamespacedName)
 		return ctrl.Result{}, err
 	}
 
 	return ctrl.Result{}, nil
 }
 
-func (r *MutatingWebhookConfigurationReconciler) patchCaBundle(m *admissionv1.MutatingWebhookConfiguration) error {
-	ctx := context.Background()
-
-	current := m.DeepCopy()
-	for i := range m.Webhooks {
-		m.Webhooks[i].ClientConfig.CABundle = r.CertContext.SigningCert
-	}
-
-	if reflect.DeepEqual(m.Webhooks, current.Webhooks) {
-		r.Log.Info("no need to patch the MutatingWebhookConfiguration", "name", m.GetName())
-		return nil
-	}
-
-	if err := r.Patch(ctx, m, client.MergeFrom(current)); err != nil {
-		r.Log.Error(err, "fail to patch CABundle to mutatingWebHook", "name", m.GetName())
-		return err
-	}
-
-	r.Log.Info("finished patch MutatingWebhookConfiguration caBundle", "name", m.GetName())
-
-	return nil
-}
-
-// add
-
-var filterByWebhookName = &predicate.Funcs{
-	CreateFunc: createPredicate,
-	UpdateFunc: updatePredicate,
-}
-
-func createPredicate(e event.CreateEvent) bool {
-	obj, ok := e.Object.(*admissionv1.MutatingWebhookConfiguration)
-	if !ok {
-		return false
-	}
-
-	if obj.GetName() == common.WebHookName {
-		return true
-	}
-
-	return false
-}
-
-func updatePredicate(e event.UpdateEvent) bool {
-	obj, ok := e.ObjectOld.(*admissionv1.MutatingWebhookConfiguration)
-	if !ok {
-		return false
-	}
-
-	if obj.GetName() == common.WebHookName {
-		return true
-	}
-
-	return false
+// configurationsForCertChange maps a certificate change to every MutatingWebhookConfiguration
+// the event filter lets through
+func (r *MutatingWebhookConfigurationReconciler) configurationsForCertChange(_ client.Object) []reconcile.Request {
+	return requestsForSelected(context.Background(), r.Client, r.Log, &admissionv1.MutatingWebhookConfigurationList{}, r.Selector)
 }
 
 // MutatingWebhookConfiguration
 // SetupWithManager sets up the controller with the Manager.
-func (r *MutatingWebhookConfigurationReconciler) SetupWithManager(mgr ctrl.Manager, l logr.Logger, certContext *common.CertContext) error {
+func (r *MutatingWebhookConfigurationReconciler) SetupWithManager(mgr ctrl.Manager, l logr.Logger, certs CertSource, selector InjectionSelector) error {
+	rec := NewMutatingWebhookConfigurationReconciler(mgr, l, certs, selector)
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&admissionv1.MutatingWebhookConfiguration{}).
-		WithEventFilter(filterByWebhookName).
-		Complete(
-			NewMutatingWebhookConfigurationReconciler(mgr, l, certContext),
-		)
+		For(&admissionv1.MutatingWebhookConfiguration{}, builder.WithPredicates(selector.Predicate())).
+		// inject the CA bundle again whenever the certificate is rotated
+		Watches(&source.Channel{Source: certs.Subscribe()}, handler.EnqueueRequestsFromMapFunc(rec.configurationsForCertChange)).
+		Complete(rec)
 }
 
-func NewMutatingWebhookConfigurationReconciler(mgr ctrl.Manager, l logr.Logger, certContext *common.CertContext) *MutatingWebhookConfigurationReconciler {
+func NewMutatingWebhookConfigurationReconciler(mgr ctrl.Manager, l logr.Logger, certs CertSource, selector InjectionSelector) *MutatingWebhookConfigurationReconciler {
 	r := &MutatingWebhookConfigurationReconciler{}
 	r.Client = mgr.GetClient()
 	r.Log = l
-	r.CertContext = certContext
+	r.Certs = certs
+	r.Selector = selector
 	return r
 }
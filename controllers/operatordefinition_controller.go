@@ -0,0 +1,303 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/yaml"
+
+	openaiosv1alpha1 "github.com/allenhaozi/webhook/api/openaios/v1alpha1"
+	"github.com/allenhaozi/webhook/pkg/helm"
+	"github.com/allenhaozi/webhook/pkg/spark"
+)
+
+// SparkApplicationGVK is the kind of the spark operator applications, handled
+// as unstructured objects
+var SparkApplicationGVK = schema.GroupVersionKind{Group: "sparkoperator.k8s.io", Version: "v1beta2", Kind: "SparkApplication"}
+
+// reasons of the OperatorDefinition conditions
+const (
+	reasonApplicationCreated  = "ApplicationCreated"
+	reasonRenderFailed        = "RenderFailed"
+	reasonApplicationNotFound = "ApplicationNotFound"
+)
+
+// states of a SparkApplication, pending stands for an application without state yet
+const (
+	sparkStatePending          = "PENDING"
+	sparkStateCompleted        = "COMPLETED"
+	sparkStateFailed           = "FAILED"
+	sparkStateSubmissionFailed = "SUBMISSION_FAILED"
+)
+
+// OperatorDefinitionReconciler renders OperatorDefinitions into SparkApplications
+type OperatorDefinitionReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Log    logr.Logger
+	// ChartDir holds the charts of the operators
+	ChartDir string
+}
+
+//+kubebuilder:rbac:groups=openaios.4pd.io,resources=operatordefinitions,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=openaios.4pd.io,resources=operatordefinitions/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=openaios.4pd.io,resources=operatordefinitions/finalizers,verbs=update
+//+kubebuilder:rbac:groups=sparkoperator.k8s.io,resources=sparkapplications,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile creates the SparkApplication of each generation of an OperatorDefinition,
+// rendered by the chart of the operator with the OperatorDefinition as values,
+// and mirrors the state of the application into the status.
+func (r *OperatorDefinitionReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var od openaiosv1alpha1.OperatorDefinition
+	if err := r.Get(ctx, req.NamespacedName, &od); err != nil {
+		// the SparkApplications are garbage collected with their owner
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+
+	current := od.DeepCopy()
+	var err error
+	if od.Status.ObservedGeneration != od.Generation || od.Status.SparkApplication == "" {
+		err = r.createApplication(ctx, &od)
+	}
+	if err == nil {
+		err = r.mirrorApplication(ctx, &od)
+	}
+
+	if patchErr := r.Status().Patch(ctx, &od, client.MergeFrom(current)); patchErr != nil {
+		r.Log.Error(patchErr, "fail to update OperatorDefinition status", "operatordefinition", req.NamespacedName)
+		return ctrl.Result{}, patchErr
+	}
+
+	return ctrl.Result{}, err
+}
+
+// createApplication renders and creates the SparkApplication of the current
+// generation, which supersedes the SparkApplications of the former generations.
+// A chart failing to render is recorded in the Rendered condition and leaves the
+// former SparkApplication in place.
+func (r *OperatorDefinitionReconciler) createApplication(ctx context.Context, od *openaiosv1alpha1.OperatorDefinition) error {
+	od.Status.ObservedGeneration = od.Generation
+	od.Status.SparkApplication = ""
+	od.Status.ApplicationState = ""
+	od.Status.Message = ""
+	meta.RemoveStatusCondition(&od.Status.Conditions, openaiosv1alpha1.ConditionSucceeded)
+
+	app, err := r.renderApplication(ctx, od)
+	if err != nil {
+		r.Log.Info("fail to render SparkApplication", "operatordefinition", client.ObjectKeyFromObject(od), "reason", err.Error())
+		r.setCondition(od, openaiosv1alpha1.ConditionRendered, k8smetav1.ConditionFalse, reasonRenderFailed, err.Error())
+		return nil
+	}
+
+	if err := r.Create(ctx, app); err != nil {
+		if !apierrors.IsAlreadyExists(err) {
+			return errors.Wrapf(err, "fail to create SparkApplication %s", app.GetName())
+		}
+		// a previous reconcile created it but failed to record it
+		existing := &unstructured.Unstructured{}
+		existing.SetGroupVersionKind(SparkApplicationGVK)
+		if err := r.Get(ctx, client.ObjectKeyFromObject(app), existing); err != nil {
+			return errors.Wrapf(err, "fail to get SparkApplication %s", app.GetName())
+		}
+		if !k8smetav1.IsControlledBy(existing, od) {
+			err := errors.Errorf("SparkApplication %s exists and is not owned by the OperatorDefinition", app.GetName())
+			r.setCondition(od, openaiosv1alpha1.ConditionRendered, k8smetav1.ConditionFalse, reasonRenderFailed, err.Error())
+			return nil
+		}
+	}
+	r.Log.Info("SparkApplication created", "operatordefinition", client.ObjectKeyFromObject(od), "sparkapplication", app.GetName())
+
+	if err := r.deleteSupersededApplications(ctx, od, app.GetName()); err != nil {
+		return err
+	}
+
+	od.Status.SparkApplication = app.GetName()
+	r.setCondition(od, openaiosv1alpha1.ConditionRendered, k8smetav1.ConditionTrue, reasonApplicationCreated,
+		fmt.Sprintf("SparkApplication %s created for generation %d", app.GetName(), od.Generation))
+	return nil
+}
+
+// deleteSupersededApplications deletes the SparkApplications od controls but current
+func (r *OperatorDefinitionReconciler) deleteSupersededApplications(ctx context.Context, od *openaiosv1alpha1.OperatorDefinition, current string) error {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(SparkApplicationGVK.GroupVersion().WithKind(SparkApplicationGVK.Kind + "List"))
+	if err := r.List(ctx, list, client.InNamespace(od.Namespace)); err != nil {
+		return errors.Wrap(err, "fail to list SparkApplications")
+	}
+
+	for i := range list.Items {
+		app := &list.Items[i]
+		if app.GetName() == current || !k8smetav1.IsControlledBy(app, od) {
+			continue
+		}
+		if err := r.Delete(ctx, app, client.PropagationPolicy(k8smetav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return errors.Wrapf(err, "fail to delete superseded SparkApplication %s", app.GetName())
+		}
+		r.Log.Info("superseded SparkApplication deleted", "operatordefinition", client.ObjectKeyFromObject(od), "sparkapplication", app.GetName())
+	}
+	return nil
+}
+
+// renderApplication renders the chart of od and returns its SparkApplication,
+// named after od and its generation, controlled by od and given the credentials of od
+func (r *OperatorDefinitionReconciler) renderApplication(ctx context.Context, od *openaiosv1alpha1.OperatorDefinition) (*unstructured.Unstructured, error) {
+	if r.ChartDir == "" {
+		return nil, errors.New("chart rendering is disabled, no chart directory configured")
+	}
+	chart := od.ChartName()
+	if chart == "" {
+		return nil, errors.Errorf("neither spec.chart nor label %s names a chart", openaiosv1alpha1.LabelName)
+	}
+	chartPath := openaiosv1alpha1.ChartPath(r.ChartDir, chart)
+
+	values, err := od.ChartValues()
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := helm.Render(ctx,
+		helm.ChartSource{Path: chartPath},
+		helm.Values{Maps: []map[string]interface{}{values}},
+		helm.ReleaseOptions{Name: od.Name, Namespace: od.Namespace},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var app *unstructured.Unstructured
+	for _, m := range manifests {
+		if m.Kind != SparkApplicationGVK.Kind {
+			continue
+		}
+		if app != nil {
+			return nil, errors.Errorf("chart %s renders more than one %s", chart, SparkApplicationGVK.Kind)
+		}
+		app = &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(m.Content), &app.Object); err != nil {
+			return nil, errors.Wrapf(err, "fail to parse %s of chart %s", m.Kind, chart)
+		}
+	}
+	if app == nil {
+		return nil, errors.Errorf("chart %s renders no %s", chart, SparkApplicationGVK.Kind)
+	}
+	if err := spark.InjectCredentials(app.Object, od.Credentials); err != nil {
+		return nil, errors.Wrap(err, "fail to inject credentials")
+	}
+
+	app.SetGroupVersionKind(SparkApplicationGVK)
+	app.SetGenerateName("")
+	app.SetName(fmt.Sprintf("%s-%d", od.Name, od.Generation))
+	app.SetNamespace(od.Namespace)
+	if err := controllerutil.SetControllerReference(od, app, r.Scheme); err != nil {
+		return nil, err
+	}
+
+	return app, nil
+}
+
+// mirrorApplication copies the state of the SparkApplication of od into its status
+func (r *OperatorDefinitionReconciler) mirrorApplication(ctx context.Context, od *openaiosv1alpha1.OperatorDefinition) error {
+	if od.Status.SparkApplication == "" {
+		return nil
+	}
+
+	app := &unstructured.Unstructured{}
+	app.SetGroupVersionKind(SparkApplicationGVK)
+	key := types.NamespacedName{Namespace: od.Namespace, Name: od.Status.SparkApplication}
+	if err := r.Get(ctx, key, app); err != nil {
+		if apierrors.IsNotFound(err) {
+			od.Status.ApplicationState = ""
+			r.setCondition(od, openaiosv1alpha1.ConditionSucceeded, k8smetav1.ConditionFalse, reasonApplicationNotFound,
+				fmt.Sprintf("SparkApplication %s not found", key.Name))
+			return nil
+		}
+		return errors.Wrapf(err, "fail to get SparkApplication %s", key.Name)
+	}
+
+	state, _, _ := unstructured.NestedString(app.Object, "status", "applicationState", "state")
+	message, _, _ := unstructured.NestedString(app.Object, "status", "applicationState", "errorMessage")
+	od.Status.ApplicationState = state
+	od.Status.Message = message
+
+	status := k8smetav1.ConditionUnknown
+	switch state {
+	case sparkStateCompleted:
+		status = k8smetav1.ConditionTrue
+	case sparkStateFailed, sparkStateSubmissionFailed:
+		status = k8smetav1.ConditionFalse
+	}
+	// the states are valid condition reasons, e.g. SUBMISSION_FAILED
+	reason := state
+	if reason == "" {
+		reason = sparkStatePending
+	}
+	if message == "" {
+		message = fmt.Sprintf("SparkApplication %s is %s", key.Name, reason)
+	}
+	r.setCondition(od, openaiosv1alpha1.ConditionSucceeded, status, reason, message)
+
+	return nil
+}
+
+func (r *OperatorDefinitionReconciler) setCondition(od *openaiosv1alpha1.OperatorDefinition, t string, status k8smetav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&od.Status.Conditions, k8smetav1.Condition{
+		Type:               t,
+		Status:             status,
+		ObservedGeneration: od.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *OperatorDefinitionReconciler) SetupWithManager(mgr ctrl.Manager, l logr.Logger, chartDir string) error {
+	app := &unstructured.Unstructured{}
+	app.SetGroupVersionKind(SparkApplicationGVK)
+
+	return ctrl.NewControllerManagedBy(mgr).
+		// status updates must not render again, the applications are watched instead
+		For(&openaiosv1alpha1.OperatorDefinition{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Owns(app).
+		Complete(
+			NewOperatorDefinitionReconciler(mgr, l, chartDir),
+		)
+}
+
+func NewOperatorDefinitionReconciler(mgr ctrl.Manager, l logr.Logger, chartDir string) *OperatorDefinitionReconciler {
+	r := &OperatorDefinitionReconciler{}
+	r.Log = l
+	r.Client = mgr.GetClient()
+	r.Scheme = mgr.GetScheme()
+	r.ChartDir = chartDir
+	return r
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/meta"
+	k8smetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	openaiosv1alpha1 "github.com/allenhaozi/webhook/api/openaios/v1alpha1"
+)
+
+func TestOperatorDefinitionReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := openaiosv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	od := &openaiosv1alpha1.OperatorDefinition{
+		ObjectMeta: k8smetav1.ObjectMeta{
+			Name:       "salesforecast",
+			Namespace:  "default",
+			Generation: 3,
+			UID:        "8a6d",
+			Labels:     map[string]string{openaiosv1alpha1.LabelName: "salesforecast"},
+		},
+		Spec: openaiosv1alpha1.OperatorDefinitionSpec{
+			Inputs: []openaiosv1alpha1.OperatorResource{{Name: "input-01", ResourceType: "table"}},
+		},
+		Credentials: &openaiosv1alpha1.Credentials{HDFS: []openaiosv1alpha1.HDFSCredential{{
+			Name:                     "hdfs-default",
+			KerberosConfigurationDir: "/opt/spark/etc/kerberos-default",
+			KerberosSecret:           "hdfs-default-kerberos",
+		}}},
+		SparkConfiguration: map[string]string{"spark.driver.maxResultSize": "0"},
+		RuntimeConfig: &openaiosv1alpha1.RuntimeConfig{
+			Image: &openaiosv1alpha1.Image{Repository: "spark-py", Tag: "v3.2.1"},
+		},
+	}
+	r := &OperatorDefinitionReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(od).Build(),
+		Scheme:   scheme,
+		Log:      logr.Discard(),
+		ChartDir: "../charts",
+	}
+
+	key := types.NamespacedName{Name: "salesforecast", Namespace: "default"}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	app := &unstructured.Unstructured{}
+	app.SetGroupVersionKind(SparkApplicationGVK)
+	if err := r.Get(context.Background(), types.NamespacedName{Name: "salesforecast-3", Namespace: "default"}, app); err != nil {
+		t.Fatal(err)
+	}
+	image, _, _ := unstructured.NestedString(app.Object, "spec", "image")
+	conf, _, _ := unstructured.NestedString(app.Object, "spec", "sparkConf", "spark.driver.maxResultSize")
+	if image != "spark-py:v3.2.1" || conf != "0" {
+		t.Errorf("unexpected SparkApplication spec %v", app.Object["spec"])
+	}
+	secrets, _, _ := unstructured.NestedSlice(app.Object, "spec", "executor", "secrets")
+	if len(secrets) != 1 {
+		t.Errorf("expected the kerberos secret to be mounted, got %v", secrets)
+	}
+	if owners := app.GetOwnerReferences(); len(owners) != 1 || owners[0].UID != od.UID {
+		t.Errorf("expected the SparkApplication to be owned by the OperatorDefinition, got %v", owners)
+	}
+
+	// the state of the application is mirrored into the status
+	if err := unstructured.SetNestedField(app.Object, "FAILED", "status", "applicationState", "state"); err != nil {
+		t.Fatal(err)
+	}
+	if err := unstructured.SetNestedField(app.Object, "driver pod failed", "status", "applicationState", "errorMessage"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Update(context.Background(), app); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := &openaiosv1alpha1.OperatorDefinition{}
+	if err := r.Get(context.Background(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	succeeded := meta.FindStatusCondition(got.Status.Conditions, openaiosv1alpha1.ConditionSucceeded)
+	if got.Status.SparkApplication != "salesforecast-3" || got.Status.ObservedGeneration != 3 ||
+		got.Status.ApplicationState != "FAILED" || got.Status.Message != "driver pod failed" ||
+		!meta.IsStatusConditionTrue(got.Status.Conditions, openaiosv1alpha1.ConditionRendered) ||
+		succeeded == nil || succeeded.Status != k8smetav1.ConditionFalse {
+		t.Errorf("unexpected status %+v", got.Status)
+	}
+
+	// the application of a new generation supersedes the former one
+	got.Generation = 4
+	if err := r.Update(context.Background(), got); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatal(err)
+	}
+	apps := &unstructured.UnstructuredList{}
+	apps.SetGroupVersionKind(SparkApplicationGVK.GroupVersion().WithKind(SparkApplicationGVK.Kind + "List"))
+	if err := r.List(context.Background(), apps); err != nil {
+		t.Fatal(err)
+	}
+	if len(apps.Items) != 1 || apps.Items[0].GetName() != "salesforecast-4" {
+		names := []string{}
+		for _, item := range apps.Items {
+			names = append(names, item.GetName())
+		}
+		t.Errorf("expected only salesforecast-4 to be left, got %v", names)
+	}
+}
+
+func TestOperatorDefinitionReconcileRenderFailure(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := openaiosv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	od := &openaiosv1alpha1.OperatorDefinition{
+		ObjectMeta: k8smetav1.ObjectMeta{Name: "missing", Namespace: "default", Generation: 1},
+		Spec:       openaiosv1alpha1.OperatorDefinitionSpec{Chart: "missing"},
+	}
+	r := &OperatorDefinitionReconciler{
+		Client:   fake.NewClientBuilder().WithScheme(scheme).WithObjects(od).Build(),
+		Scheme:   scheme,
+		Log:      logr.Discard(),
+		ChartDir: "../charts",
+	}
+
+	key := types.NamespacedName{Name: "missing", Namespace: "default"}
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+		t.Fatalf("expected render failures to be recorded only, got %v", err)
+	}
+
+	got := &openaiosv1alpha1.OperatorDefinition{}
+	if err := r.Get(context.Background(), key, got); err != nil {
+		t.Fatal(err)
+	}
+	rendered := meta.FindStatusCondition(got.Status.Conditions, openaiosv1alpha1.ConditionRendered)
+	if rendered == nil || rendered.Status != k8smetav1.ConditionFalse || rendered.Reason != reasonRenderFailed || got.Status.SparkApplication != "" {
+		t.Errorf("unexpected status %+v", got.Status)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// ValidatingWebhookConfigurationReconciler injects the CA bundle into a ValidatingWebhookConfiguration
+type ValidatingWebhookConfigurationReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	Certs  CertSource
+	Log    logr.Logger
+	// Selector picks the configurations the CA bundle is injected into
+	Selector InjectionSelector
+}
+
+// +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=validatingwebhookconfigurations,verbs=get;list;watch;update;patch
+
+func (r *ValidatingWebhookConfigurationReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	var v admissionv1.ValidatingWebhookConfiguration
+	if err := r.Get(ctx, req.NamespacedName, &v); err != nil {
+		// a deleted object has no caBundle left to inject
+		if err = client.IgnoreNotFound(err); err != nil {
+			r.Log.Error(err, "fail to get validatingWebHookConfiguration", "name", req.NamespacedName)
+		}
+		return ctrl.Result{}, err
+	}
+	// the configuration may have been deselected after the request was queued
+	if !r.Selector.Matches(&v) {
+		return ctrl.Result{}, nil
+	}
+
+	r.Log.Info("received validatingwebhookconfiguration data", "ValidatingWebhookConfiguration", req.NamespacedName)
+
+	if err := patchCaBundle(ctx, r.Client, r.Log, &v, r.Certs.CertContext().CABundle); err != nil {
+		r.Log.Error(err, "fail to patch CABundle to validatingWebHookConfiguration", "name", req.NamespacedName)
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// configurationsForCertChange maps a certificate change to every ValidatingWebhookConfiguration
+// the event filter lets through
+func (r *ValidatingWebhookConfigurationReconciler) configurationsForCertChange(_ client.Object) []reconcile.Request {
+	return requestsForSelected(context.Background(), r.Client, r.Log, &admissionv1.ValidatingWebhookConfigurationList{}, r.Selector)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ValidatingWebhookConfigurationReconciler) SetupWithManager(mgr ctrl.Manager, l logr.Logger, certs CertSource, selector InjectionSelector) error {
+	rec := NewValidatingWebhookConfigurationReconciler(mgr, l, certs, selector)
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&admissionv1.ValidatingWebhookConfiguration{}, builder.WithPredicates(selector.Predicate())).
+		// inject the CA bundle again whenever the certificate is rotated
+		Watches(&source.Channel{Source: certs.Subscribe()}, handler.EnqueueRequestsFromMapFunc(rec.configurationsForCertChange)).
+		Complete(rec)
+}
+
+func NewValidatingWebhookConfigurationReconciler(mgr ctrl.Manager, l logr.Logger, certs CertSource, selector InjectionSelector) *ValidatingWebhookConfigurationReconciler {
+	r := &ValidatingWebhookConfigurationReconciler{}
+	r.Client = mgr.GetClient()
+	r.Log = l
+	r.Certs = certs
+	r.Selector = selector
+	return r
+}
@@ -17,11 +17,19 @@ limitations under the License.
 package main
 
 import (
+	"crypto/tls"
 	"flag"
+	"net"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -31,10 +39,13 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	"github.com/allenhaozi/webhook/api/common"
+	openaiosv1alpha1 "github.com/allenhaozi/webhook/api/openaios/v1alpha1"
 	webhookv1 "github.com/allenhaozi/webhook/api/v1"
 	webhookv1alpha1 "github.com/allenhaozi/webhook/api/v1alpha1"
 	"github.com/allenhaozi/webhook/controllers"
+	"github.com/allenhaozi/webhook/pkg/catalog"
 	"github.com/allenhaozi/webhook/pkg/manager"
+	webhookutils "github.com/allenhaozi/webhook/pkg/utils"
 )
 
 var (
@@ -44,9 +55,11 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 
 	utilruntime.Must(webhookv1.AddToScheme(scheme))
 	utilruntime.Must(webhookv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(openaiosv1alpha1.AddToScheme(scheme))
 
 	// add argoproj workflow
 	// utilruntime.Must(argoworkflowv1alpha1.AddToScheme(scheme))
@@ -61,8 +74,56 @@ func main() {
 	var enableLeaderElection bool
 	var probeAddr string
 	var certDir string
+	var chartDir string
+	var policyNamespace string
+	var catalogEndpoint string
+	var certRotateBefore time.Duration
+	var certCheckInterval time.Duration
+	var injectCAInto string
+	var namespace string
+	var serviceName string
+	var certMode string
+	var certSecret string
+	var certFilesCheckInterval time.Duration
+	var certDNSNames string
+	var certIPAddresses string
+	var certKeyAlgorithm string
+	var certValidity time.Duration
+	var caValidity time.Duration
+	var caCommonName string
+	var catalogResyncPeriod time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&certDir, "cert-dir", "/tmp/k8s-webhook-server/serving-certs", "webhook certificate.")
+	flag.StringVar(&namespace, "namespace", "", "The namespace of the webhook service and certificate secret, defaults to "+
+		"the "+common.MyPodNamespace+" environment variable, the pod namespace or the namespace of the kubeconfig context.")
+	flag.StringVar(&serviceName, "service-name", common.WebHookName, "The service the API server reaches the webhook through.")
+	flag.StringVar(&certMode, "cert-mode", string(manager.CertModeSelfSigned), "Where the webhook certificate comes from: "+
+		"self-signed (generated, stored in the certificate secret and rotated), "+
+		"secret (an existing kubernetes.io/tls secret, e.g. issued by cert-manager, never written) "+
+		"or files (the certificate mounted in the certificate directory).")
+	flag.StringVar(&certSecret, "cert-secret", common.WebHookName, "The secret holding the webhook certificate.")
+	flag.DurationVar(&certFilesCheckInterval, "cert-files-check-interval", time.Minute, "How often the mounted certificate files are "+
+		"checked for changes in the files certificate mode.")
+	flag.StringVar(&certDNSNames, "cert-dns-names", "", "Comma separated DNS names added to the service names of the webhook certificate.")
+	flag.StringVar(&certIPAddresses, "cert-ip-addresses", "", "Comma separated IP addresses of the webhook certificate, "+
+		"for a webhook reached by URL.")
+	flag.StringVar(&certKeyAlgorithm, "cert-key-algorithm", string(webhookutils.KeyAlgorithmRSA), "The algorithm of the certificate keys: "+
+		"rsa, ecdsa (P-256) or ed25519.")
+	flag.DurationVar(&certValidity, "cert-validity", webhookutils.DefaultCertValidity, "How long the webhook certificate is valid.")
+	flag.DurationVar(&caValidity, "ca-validity", webhookutils.DefaultCertValidity, "How long a newly created CA is valid.")
+	flag.StringVar(&caCommonName, "ca-common-name", webhookutils.DefaultCACommonName, "The common name of a newly created CA.")
+	flag.DurationVar(&certRotateBefore, "cert-rotate-before", 30*24*time.Hour, "How long before expiry the webhook certificate is rotated.")
+	flag.DurationVar(&certCheckInterval, "cert-check-interval", time.Hour, "How often the webhook certificate is checked for rotation.")
+	flag.StringVar(&injectCAInto, "inject-ca-into", "", "Comma separated names of the webhook configurations, "+
+		"CustomResourceDefinitions and APIServices the CA bundle is injected into, "+
+		"besides those annotated with "+common.InjectCAAnnotation+".")
+	flag.StringVar(&chartDir, "chart-dir", "", "The directory holding the charts argo resource templates and OperatorDefinitions may reference, "+
+		"chart rendering and the OperatorDefinition controller are disabled when empty.")
+	flag.StringVar(&policyNamespace, "policy-namespace", "", "The namespace whose ManifestRenderPolicies may select workflows "+
+		"of other namespaces by namespaceSelector, policies of other namespaces only select workflows of their own namespace.")
+	flag.StringVar(&catalogEndpoint, "catalog-endpoint", "", "The OpenMetadata endpoint tables are resolved against, "+
+		"the bearer token is read from the "+common.CatalogToken+" environment variable.")
+	flag.DurationVar(&catalogResyncPeriod, "catalog-resync-period", 10*time.Minute, "How often table metadata is synced again from the catalog.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
@@ -100,57 +161,191 @@ func main() {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
+	var catalogClient catalog.Client
+	if catalogEndpoint != "" {
+		catalogClient = catalog.NewOpenMetadataClient(catalogEndpoint, os.Getenv(common.CatalogToken))
+	} else {
+		setupLog.Info("no catalog endpoint configured, tables won't be resolved")
+	}
+
 	setupLog.Info("start webhook controller")
 	if err = (&controllers.MetaWebHookReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr, setupLog); err != nil {
+	}).SetupWithManager(mgr, setupLog, catalogClient, catalogResyncPeriod); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "MetaWebHook")
 		os.Exit(1)
 	}
 
+	// operator runs are SparkApplications rendered by charts, the controller is
+	// set up only given a chart directory and a cluster serving SparkApplications,
+	// watching a kind the cluster doesn't know would keep the manager from starting
+	if chartDir == "" {
+		setupLog.Info("no chart directory configured, OperatorDefinitions won't be reconciled")
+	} else if _, err := mgr.GetRESTMapper().RESTMapping(controllers.SparkApplicationGVK.GroupKind(), controllers.SparkApplicationGVK.Version); err != nil {
+		setupLog.Info("SparkApplications are not served, OperatorDefinitions won't be reconciled", "reason", err.Error())
+	} else if err = (&controllers.OperatorDefinitionReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr, setupLog, chartDir); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "OperatorDefinition")
+		os.Exit(1)
+	}
+
 	cfg, _ := ctrl.GetConfig()
 	client, _ := client.New(cfg, client.Options{})
 	// certificate manager
 	// generate certificate
 	// 1. store it in secret
 	// 2. save in local pod path certDir
-	certManager := manager.NewCertificateManager(client, setupLog, certDir)
-
-	ns := ""
-	if v, ok := os.LookupEnv(common.MyPodNamespace); ok {
-		ns = v
-	} else {
-		setupLog.Error(err, "get environment failure")
+	certOptions := webhookutils.CertOptions{
+		DNSNames:     splitList(certDNSNames),
+		KeyAlgorithm: webhookutils.KeyAlgorithm(certKeyAlgorithm),
+		Validity:     certValidity,
+		CAValidity:   caValidity,
+		CACommonName: caCommonName,
+	}
+	for _, v := range splitList(certIPAddresses) {
+		ip := net.ParseIP(v)
+		if ip == nil {
+			setupLog.Error(nil, "invalid certificate IP address", "address", v)
+			os.Exit(1)
+		}
+		certOptions.IPs = append(certOptions.IPs, ip)
+	}
+	if err := certOptions.Validate(); err != nil {
+		setupLog.Error(err, "invalid certificate options")
 		os.Exit(1)
 	}
+	// a certificate valid for less than the rotation lead time would be rotated on every check
+	if certValidity <= certRotateBefore || caValidity <= certRotateBefore {
+		setupLog.Error(nil, "certificate and CA validity must exceed the rotation lead time", "cert-rotate-before", certRotateBefore)
+		os.Exit(1)
+	}
+	certManager := manager.NewCertificateManager(client, setupLog, certDir, serviceName, certOptions)
 
-	certContext, err := certManager.GenerateCertificate(ns, common.WebHookName)
+	ns, err := webhookutils.DiscoverNamespace(namespace)
 	if err != nil {
-		setupLog.Error(err, "generate certification failure")
+		setupLog.Error(err, "unable to discover the namespace, set it with --namespace")
 		os.Exit(1)
 	}
+	setupLog.Info("webhook namespace", "namespace", ns)
+
+	// certChecker builds the readiness check comparing the served certificate with its source
+	var certChecker func(addr string) healthz.Checker
+	switch manager.CertMode(certMode) {
+	case manager.CertModeSelfSigned:
+		if _, err := certManager.GenerateCertificate(ns, certSecret); err != nil {
+			setupLog.Error(err, "generate certification failure")
+			os.Exit(1)
+		}
+
+		// rotate the certificate before it expires
+		certRotator := manager.NewCertificateRotator(certManager, ns, serviceName, certRotateBefore, certCheckInterval)
+		if err := mgr.Add(certRotator); err != nil {
+			setupLog.Error(err, "unable to set up certificate rotation")
+			os.Exit(1)
+		}
+	case manager.CertModeSecret:
+		if _, err := certManager.LoadCertificateFromSecret(ns, certSecret); err != nil {
+			setupLog.Error(err, "load certification failure", "secret", certSecret)
+			os.Exit(1)
+		}
+	case manager.CertModeFiles:
+		if _, err := certManager.LoadCertificateFromFiles(); err != nil {
+			setupLog.Error(err, "load certification failure", "dir", certDir)
+			os.Exit(1)
+		}
+
+		// serve the mounted certificate as soon as it changes
+		certFileWatcher := manager.NewCertificateFileWatcher(certManager, certFilesCheckInterval)
+		if err := mgr.Add(certFileWatcher); err != nil {
+			setupLog.Error(err, "unable to set up certificate watcher")
+			os.Exit(1)
+		}
+		certChecker = certFileWatcher.Checker
+	default:
+		setupLog.Error(nil, "unknown certificate mode", "cert-mode", certMode)
+		os.Exit(1)
+	}
+
+	if certChecker == nil {
+		// serve the certificate of the secret as soon as it changes
+		certWatcher, err := manager.NewCertificateWatcher(certManager, mgr.GetConfig(), mgr.GetScheme(), ns, certSecret)
+		if err != nil {
+			setupLog.Error(err, "unable to set up certificate watcher")
+			os.Exit(1)
+		}
+		if err := mgr.Add(certWatcher); err != nil {
+			setupLog.Error(err, "unable to set up certificate watcher")
+			os.Exit(1)
+		}
+		certChecker = certWatcher.Checker
+	}
+
+	// webhook configurations to inject the CA bundle into
+	injectionSelector := controllers.InjectionSelector{
+		Secret: types.NamespacedName{Namespace: ns, Name: certSecret},
+		Names:  sets.NewString(),
+	}
+	injectionSelector.Names.Insert(splitList(injectCAInto)...)
 
 	if err = (&controllers.MutatingWebhookConfigurationReconciler{
 		Client: mgr.GetClient(),
 		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr, setupLog, certContext); err != nil {
+	}).SetupWithManager(mgr, setupLog, certManager, injectionSelector); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "MutatingWebhookConfigurationReconciler")
 		os.Exit(1)
 	}
 
+	if err = (&controllers.ValidatingWebhookConfigurationReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr, setupLog, certManager, injectionSelector); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ValidatingWebhookConfigurationReconciler")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.CustomResourceDefinitionReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr, setupLog, certManager, injectionSelector); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "CustomResourceDefinitionReconciler")
+		os.Exit(1)
+	}
+
+	if err = (&controllers.APIServiceReconciler{
+		Client: mgr.GetClient(),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr, setupLog, certManager, injectionSelector); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "APIServiceReconciler")
+		os.Exit(1)
+	}
+
 	// webhook register
 
 	setupLog.Info("start webhook server and register it with SetupWebhookWithManager")
 
-	if err = (&webhookv1.MetaWebHook{}).SetupWebhookWithManager(mgr); err != nil {
+	if err = (&webhookv1.MetaWebHook{}).SetupWebhookWithManager(mgr, catalogClient); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "MetaWebHook")
 		os.Exit(1)
 	}
+	if err = (&openaiosv1alpha1.OperatorDefinition{}).SetupWebhookWithManager(mgr, catalogClient, chartDir); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "OperatorDefinition")
+		os.Exit(1)
+	}
 
 	hookServer := mgr.GetWebhookServer()
+	hookServer.TLSOpts = append(hookServer.TLSOpts, func(cfg *tls.Config) {
+		cfg.GetCertificate = certManager.GetCertificate
+	})
 
-	hookServer.Register("/mutate-v1alpha1-argoworkflow", &webhook.Admission{Handler: &webhookv1alpha1.ArgoWorkflowHandler{Client: mgr.GetClient(), Log: setupLog}})
+	// workflows, workflow templates and cron workflows share the manifest rendering
+	argoHandler := webhookv1alpha1.ArgoWorkflowHandler{Client: mgr.GetAPIReader(), Log: setupLog, ChartDir: chartDir, PolicyNamespace: policyNamespace}
+	hookServer.Register("/mutate-v1alpha1-argoworkflow", &webhook.Admission{Handler: &argoHandler})
+	hookServer.Register("/mutate-v1alpha1-argoworkflowtemplate", &webhook.Admission{Handler: &webhookv1alpha1.WorkflowTemplateHandler{ArgoWorkflowHandler: argoHandler}})
+	hookServer.Register("/mutate-v1alpha1-argoclusterworkflowtemplate", &webhook.Admission{Handler: &webhookv1alpha1.ClusterWorkflowTemplateHandler{ArgoWorkflowHandler: argoHandler}})
+	hookServer.Register("/mutate-v1alpha1-argocronworkflow", &webhook.Admission{Handler: &webhookv1alpha1.CronWorkflowHandler{ArgoWorkflowHandler: argoHandler}})
 
 	//+kubebuilder:scaffold:builder
 
@@ -164,6 +359,10 @@ func main() {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	if err := mgr.AddReadyzCheck("certificate", certChecker(net.JoinHostPort(hookServer.Host, strconv.Itoa(hookServer.Port)))); err != nil {
+		setupLog.Error(err, "unable to set up certificate ready check")
+		os.Exit(1)
+	}
 
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -171,3 +370,14 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// splitList splits a comma separated flag value, dropping empty items
+func splitList(v string) []string {
+	items := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
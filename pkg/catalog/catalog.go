@@ -0,0 +1,58 @@
+package catalog
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by a Client when the catalog has no such table
+var ErrNotFound = errors.New("table not found in catalog")
+
+// Client looks tables up in a metadata catalog
+type Client interface {
+	// GetTableByFQN returns the table with the fully qualified name service.database.schema.table
+	GetTableByFQN(ctx context.Context, fqn string) (*Table, error)
+	// GetTableByID returns the table with the catalog id
+	GetTableByID(ctx context.Context, id string) (*Table, error)
+}
+
+// Table is a table registered in the metadata catalog
+type Table struct {
+	ID                 string
+	Name               string
+	FullyQualifiedName string
+	// Service is the database service holding the table
+	Service        string
+	Database       string
+	DatabaseSchema string
+	ServiceType    string
+	// Path and Warehouse locate the data of lakehouse tables
+	Path      string
+	Warehouse string
+	Columns   []Column
+}
+
+// Column is a column of a catalog table
+type Column struct {
+	Name     string
+	DataType string
+}
+
+// FQN joins service, database, schema and table into a fully qualified table
+// name as OpenMetadata names tables, empty parts are skipped
+func FQN(service, database, schema, table string) string {
+	parts := []string{}
+	for _, p := range []string{service, database, schema, table} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// IsNotFound reports whether err means the catalog has no such table
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
@@ -0,0 +1,50 @@
+package catalog
+
+import (
+	"context"
+	"sync"
+)
+
+// FakeClient is an in-memory catalog for tests and local development
+type FakeClient struct {
+	mu     sync.RWMutex
+	tables map[string]Table
+}
+
+var _ Client = &FakeClient{}
+
+func NewFakeClient(tables ...Table) *FakeClient {
+	c := &FakeClient{tables: map[string]Table{}}
+	for _, t := range tables {
+		c.Add(t)
+	}
+	return c
+}
+
+// Add registers a table, replacing any table with the same id
+func (c *FakeClient) Add(t Table) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tables[t.ID] = t
+}
+
+func (c *FakeClient) GetTableByFQN(_ context.Context, fqn string) (*Table, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, t := range c.tables {
+		if t.FullyQualifiedName == fqn {
+			t := t
+			return &t, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (c *FakeClient) GetTableByID(_ context.Context, id string) (*Table, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if t, ok := c.tables[id]; ok {
+		return &t, nil
+	}
+	return nil, ErrNotFound
+}
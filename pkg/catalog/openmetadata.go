@@ -0,0 +1,114 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OpenMetadataClient looks tables up through the OpenMetadata REST API
+type OpenMetadataClient struct {
+	// Endpoint is the base url of the catalog, e.g. http://openmetadata:8585
+	Endpoint string
+	// Token is sent as bearer token when not empty
+	Token      string
+	HTTPClient *http.Client
+}
+
+var _ Client = &OpenMetadataClient{}
+
+func NewOpenMetadataClient(endpoint, token string) *OpenMetadataClient {
+	c := &OpenMetadataClient{}
+	c.Endpoint = strings.TrimSuffix(endpoint, "/")
+	c.Token = token
+	c.HTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+	return c
+}
+
+// openMetadataTable is the subset of the OpenMetadata table entity the client reads
+type openMetadataTable struct {
+	ID                 string `json:"id"`
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	ServiceType        string `json:"serviceType"`
+	Service            struct {
+		Name string `json:"name"`
+	} `json:"service"`
+	Database struct {
+		Name string `json:"name"`
+	} `json:"database"`
+	DatabaseSchema struct {
+		Name string `json:"name"`
+	} `json:"databaseSchema"`
+	Columns []struct {
+		Name     string `json:"name"`
+		DataType string `json:"dataType"`
+	} `json:"columns"`
+	// lakehouse locations are kept as custom properties of the table
+	Extension struct {
+		Path      string `json:"path"`
+		Warehouse string `json:"warehouse"`
+	} `json:"extension"`
+}
+
+func (c *OpenMetadataClient) GetTableByFQN(ctx context.Context, fqn string) (*Table, error) {
+	return c.getTable(ctx, "/api/v1/tables/name/"+url.PathEscape(fqn))
+}
+
+func (c *OpenMetadataClient) GetTableByID(ctx context.Context, id string) (*Table, error) {
+	return c.getTable(ctx, "/api/v1/tables/"+url.PathEscape(id))
+}
+
+func (c *OpenMetadataClient) getTable(ctx context.Context, path string) (*Table, error) {
+	u := fmt.Sprintf("%s%s?fields=columns,extension", c.Endpoint, path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build catalog request")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to request catalog %s", path)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("catalog %s responded %s", path, resp.Status)
+	}
+
+	om := &openMetadataTable{}
+	if err := json.NewDecoder(resp.Body).Decode(om); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode catalog response %s", path)
+	}
+
+	t := &Table{
+		ID:                 om.ID,
+		Name:               om.Name,
+		FullyQualifiedName: om.FullyQualifiedName,
+		Service:            om.Service.Name,
+		Database:           om.Database.Name,
+		DatabaseSchema:     om.DatabaseSchema.Name,
+		ServiceType:        om.ServiceType,
+		Path:               om.Extension.Path,
+		Warehouse:          om.Extension.Warehouse,
+	}
+	for _, col := range om.Columns {
+		t.Columns = append(t.Columns, Column{Name: col.Name, DataType: col.DataType})
+	}
+
+	return t, nil
+}
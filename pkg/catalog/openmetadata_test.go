@@ -0,0 +1,46 @@
+package catalog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenMetadataClient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/api/v1/tables/name/lakehouse.salesforce.default.naton" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{
+			"id": "5c3b5d0e",
+			"name": "naton",
+			"fullyQualifiedName": "lakehouse.salesforce.default.naton",
+			"service": {"name": "lakehouse"},
+			"database": {"name": "salesforce"},
+			"databaseSchema": {"name": "default"},
+			"columns": [{"name": "sku_id", "dataType": "INT"}],
+			"extension": {"path": "/warehouse/salesforce/naton", "warehouse": "/warehouse"}
+		}`))
+	}))
+	defer server.Close()
+
+	c := NewOpenMetadataClient(server.URL+"/", "token")
+
+	table, err := c.GetTableByFQN(context.Background(), FQN("lakehouse", "salesforce", "default", "naton"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.ID != "5c3b5d0e" || table.Service != "lakehouse" || table.Path != "/warehouse/salesforce/naton" || len(table.Columns) != 1 {
+		t.Errorf("unexpected table %+v", table)
+	}
+
+	if _, err := c.GetTableByFQN(context.Background(), "lakehouse.salesforce.default.missing"); !IsNotFound(err) {
+		t.Errorf("expected not found, got %v", err)
+	}
+}
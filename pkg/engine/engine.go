@@ -0,0 +1,122 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// names of the engines
+const (
+	// Go is text/template, the default engine
+	Go = "go"
+	// Sprig is text/template with the sprig functions and toYaml/fromYaml, as helm charts use them
+	Sprig = "sprig"
+	// Jinja2 is the Jinja2 subset of Jinja2Engine
+	Jinja2 = "jinja2"
+)
+
+// Engine renders a template with values. A value the template references but
+// values lack fails the rendering.
+type Engine interface {
+	Render(name, text string, values map[string]interface{}) (string, error)
+}
+
+var engines = map[string]Engine{
+	Go:     &GoEngine{},
+	Sprig:  &GoEngine{Funcs: sprigFuncs()},
+	Jinja2: &Jinja2Engine{},
+}
+
+// Get returns the engine of name, the Go engine when name is empty
+func Get(name string) (Engine, error) {
+	if name == "" {
+		name = Go
+	}
+	e, ok := engines[name]
+	if !ok {
+		return nil, errors.Errorf("unknown template engine %q, supported engines are %s", name, strings.Join(Names(), ", "))
+	}
+	return e, nil
+}
+
+// Names returns the names of the engines
+func Names() []string {
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Error is a template failing to parse or render at a known position, the
+// errors of text/template carry their position in their message instead
+type Error struct {
+	Name string
+	// Line and Column locate the failure in the template, Column is zero when unknown
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Column > 0 {
+		return fmt.Sprintf("template: %s:%d:%d: %s", e.Name, e.Line, e.Column, e.Message)
+	}
+	if e.Line > 0 {
+		return fmt.Sprintf("template: %s:%d: %s", e.Name, e.Line, e.Message)
+	}
+	return fmt.Sprintf("template: %s: %s", e.Name, e.Message)
+}
+
+// GoEngine renders text/template templates with Funcs
+type GoEngine struct {
+	Funcs template.FuncMap
+}
+
+// Render implements Engine
+func (e *GoEngine) Render(name, text string, values map[string]interface{}) (string, error) {
+	var buf bytes.Buffer
+
+	tmpl, err := template.New(name).Option("missingkey=error").Funcs(e.Funcs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	if err := tmpl.Execute(&buf, values); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// sprigFuncs are the sprig functions with toYaml and fromYaml and without the
+// functions reading the environment of the webhook
+func sprigFuncs() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+
+	funcs["toYaml"] = func(v interface{}) string {
+		data, err := yaml.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSuffix(string(data), "\n")
+	}
+	funcs["fromYaml"] = func(s string) map[string]interface{} {
+		m := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(s), &m); err != nil {
+			m["Error"] = err.Error()
+		}
+		return m
+	}
+
+	return funcs
+}
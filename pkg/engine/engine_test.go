@@ -0,0 +1,45 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGet(t *testing.T) {
+	values := map[string]interface{}{"name": "pi", "resources": map[string]interface{}{"cores": 2}}
+	cases := []struct {
+		engine   string
+		template string
+		expected string
+	}{
+		{"", "{{ .name }}", "pi"},
+		{Go, "{{ .resources.cores }}", "2"},
+		{Sprig, `{{ .name | upper }} {{ "" | default "x" }}`, "PI x"},
+		{Sprig, "{{ toYaml .resources }}", "cores: 2"},
+		{Jinja2, "{{ name }}", "pi"},
+	}
+
+	for _, c := range cases {
+		e, err := Get(c.engine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := e.Render("t", c.template, values)
+		if err != nil {
+			t.Errorf("%s: %v", c.engine, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.engine, c.expected, out)
+		}
+	}
+
+	if _, err := Get("mustache"); err == nil || !strings.Contains(err.Error(), "go, jinja2, sprig") {
+		t.Errorf("expected an unknown engine to be rejected with the supported ones, got %v", err)
+	}
+
+	// the sprig engine does not read the environment of the webhook
+	if _, err := engines[Sprig].Render("t", `{{ env "HOME" }}`, values); err == nil {
+		t.Error("expected env to be undefined")
+	}
+}
@@ -0,0 +1,551 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Jinja2Engine renders the Jinja2 subset the operator definition files are
+// written in, with the whitespace handling of a default jinja2.Environment:
+//   - {{ expr }} outputs, {# #} comments, {%- -%} and {{- -}} whitespace control
+//   - {% for x in expr %}, {% for k, v in expr.items() %} with loop.index,
+//     loop.index0, loop.first, loop.last, loop.length and {% else %}
+//   - {% if %}, {% elif %}, {% else %} and {% set name = expr %}
+//   - and, or, not, in, is [not] defined/none/string/number/mapping/sequence,
+//     comparisons, + and - arithmetic, ~ concatenation, literals, [] indexes
+//     and list literals
+//   - the filters default (or d), upper, lower, trim, replace, join, length
+//     (or count), first, last, int, string and tojson
+//
+// As in Jinja2 a-b is a subtraction, the keys holding a hyphen, as those of
+// the operator definitions do, are subscripted: inputs['inputs-01'].
+// Referencing an undefined value fails the rendering unless it is passed to
+// default, tested or iterated, undefined values are false and iterate as empty.
+//
+// Once rendered, the !ENV tags resolving environment variables on load, as in
+// `value: !ENV ${MY_POD_NAME}`, are replaced by a quoted string. The variables,
+// written ${VAR} or ${VAR:default}, are read from the env map of the values,
+// never from the environment of the webhook.
+type Jinja2Engine struct{}
+
+// EnvValuesKey is the key of the values holding the variables of the !ENV tags
+const EnvValuesKey = "env"
+
+var (
+	envTagPattern = regexp.MustCompile(`!ENV[ \t]+("[^"\n]*"|'[^'\n]*'|[^\s#'"][^\s#]*)`)
+	envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::([^}]*))?\}`)
+)
+
+// Render implements Engine
+func (e *Jinja2Engine) Render(name, text string, values map[string]interface{}) (string, error) {
+	// like jinja2, a single trailing newline is dropped
+	text = strings.TrimSuffix(text, "\n")
+
+	segments, err := lexTemplate(name, text)
+	if err != nil {
+		return "", err
+	}
+	p := &templateParser{name: name, segments: segments}
+	nodes, end, err := p.parse()
+	if err != nil {
+		return "", err
+	}
+	if end != nil {
+		return "", p.errorf(*end, "unexpected {%% %s %%}", end.keyword())
+	}
+
+	var out strings.Builder
+	// set writes the innermost frame and never the values
+	s := &scope{frames: []map[string]interface{}{values, {}}}
+	if err := renderNodes(name, nodes, s, &out); err != nil {
+		return "", err
+	}
+
+	return resolveEnvTags(name, out.String(), values)
+}
+
+// resolveEnvTags replaces the !ENV tags of a rendered template by the quoted
+// value of their variables
+func resolveEnvTags(name, rendered string, values map[string]interface{}) (string, error) {
+	env, _ := values[EnvValuesKey].(map[string]interface{})
+
+	var resolveErr error
+	resolved := envTagPattern.ReplaceAllStringFunc(rendered, func(tag string) string {
+		scalar := envTagPattern.FindStringSubmatch(tag)[1]
+		if len(scalar) >= 2 && (scalar[0] == '"' || scalar[0] == '\'') {
+			scalar = scalar[1 : len(scalar)-1]
+		}
+
+		value := envVarPattern.ReplaceAllStringFunc(scalar, func(ref string) string {
+			m := envVarPattern.FindStringSubmatch(ref)
+			if v, ok := env[m[1]]; ok && v != nil {
+				return toString(v)
+			}
+			if strings.Contains(ref, ":") {
+				return m[2]
+			}
+			if resolveErr == nil {
+				resolveErr = &Error{Name: name, Message: fmt.Sprintf("!ENV variable %s is not set in the %s values", m[1], EnvValuesKey)}
+			}
+			return ref
+		})
+
+		quoted, _ := json.Marshal(value)
+		return string(quoted)
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+
+	return resolved, nil
+}
+
+type segmentKind int
+
+const (
+	textSegment segmentKind = iota
+	outputSegment
+	blockSegment
+)
+
+// segment is a text, an {{ output }} or a {% block %} of a template, Line and
+// Column locate the start of a tag
+type segment struct {
+	kind   segmentKind
+	text   string
+	line   int
+	column int
+}
+
+// keyword returns the first word of a block
+func (s segment) keyword() string {
+	fields := strings.Fields(s.text)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// lexTemplate splits a template into segments, comments are dropped and the
+// whitespace control of the tags is applied to the texts around them
+func lexTemplate(name, text string) ([]segment, error) {
+	segments := []segment{}
+	trimNext := false
+	line, column := 1, 1
+
+	advance := func(s string) {
+		for _, r := range s {
+			if r == '\n' {
+				line++
+				column = 1
+			} else {
+				column++
+			}
+		}
+	}
+	addText := func(s string) {
+		if trimNext {
+			s = strings.TrimLeft(s, " \t\r\n")
+		}
+		if s != "" {
+			segments = append(segments, segment{kind: textSegment, text: s})
+		}
+	}
+
+	for len(text) > 0 {
+		start := indexTagStart(text)
+		if start < 0 {
+			addText(text)
+			break
+		}
+
+		before := text[:start]
+		open := text[start : start+2]
+		closing := map[string]string{"{{": "}}", "{%": "%}", "{#": "#}"}[open]
+
+		body := text[start+2:]
+		end := strings.Index(body, closing)
+		if end < 0 {
+			advance(before)
+			return nil, &Error{Name: name, Line: line, Column: column, Message: fmt.Sprintf("%s is not closed by %s", open, closing)}
+		}
+		inner := body[:end]
+
+		if strings.HasPrefix(inner, "-") {
+			before = strings.TrimRight(before, " \t\r\n")
+			inner = inner[1:]
+		}
+		addText(before)
+		advance(text[:start])
+		tagLine, tagColumn := line, column
+
+		trimNext = false
+		if strings.HasSuffix(inner, "-") {
+			trimNext = true
+			inner = inner[:len(inner)-1]
+		}
+
+		switch open {
+		case "{{":
+			segments = append(segments, segment{kind: outputSegment, text: strings.TrimSpace(inner), line: tagLine, column: tagColumn})
+		case "{%":
+			segments = append(segments, segment{kind: blockSegment, text: strings.TrimSpace(inner), line: tagLine, column: tagColumn})
+		}
+
+		advance(text[start : start+2+end+2])
+		text = body[end+2:]
+	}
+
+	return segments, nil
+}
+
+// indexTagStart returns the index of the first {{, {% or {# of text
+func indexTagStart(text string) int {
+	for i := 0; i+1 < len(text); i++ {
+		if text[i] == '{' && (text[i+1] == '{' || text[i+1] == '%' || text[i+1] == '#') {
+			return i
+		}
+	}
+	return -1
+}
+
+// node is a parsed part of a template
+type node interface{}
+
+type textNode struct {
+	text string
+}
+
+type outputNode struct {
+	seg  segment
+	expr expr
+}
+
+type forNode struct {
+	seg      segment
+	targets  []string
+	iterable expr
+	body     []node
+	elseBody []node
+}
+
+type ifBranch struct {
+	seg  segment
+	cond expr
+	body []node
+}
+
+type ifNode struct {
+	branches []ifBranch
+	elseBody []node
+}
+
+type setNode struct {
+	seg  segment
+	name string
+	expr expr
+}
+
+type templateParser struct {
+	name     string
+	segments []segment
+	pos      int
+}
+
+func (p *templateParser) errorf(seg segment, format string, args ...interface{}) error {
+	return &Error{Name: p.name, Line: seg.line, Column: seg.column, Message: fmt.Sprintf(format, args...)}
+}
+
+// parse parses segments up to the end of the template or a block closing the
+// current one, which is returned
+func (p *templateParser) parse() ([]node, *segment, error) {
+	nodes := []node{}
+	for p.pos < len(p.segments) {
+		seg := p.segments[p.pos]
+		p.pos++
+
+		switch seg.kind {
+		case textSegment:
+			nodes = append(nodes, &textNode{text: seg.text})
+
+		case outputSegment:
+			e, err := parseExpr(seg.text)
+			if err != nil {
+				return nil, nil, p.errorf(seg, "%s", err)
+			}
+			nodes = append(nodes, &outputNode{seg: seg, expr: e})
+
+		case blockSegment:
+			switch seg.keyword() {
+			case "for":
+				n, err := p.parseFor(seg)
+				if err != nil {
+					return nil, nil, err
+				}
+				nodes = append(nodes, n)
+			case "if":
+				n, err := p.parseIf(seg)
+				if err != nil {
+					return nil, nil, err
+				}
+				nodes = append(nodes, n)
+			case "set":
+				n, err := p.parseSet(seg)
+				if err != nil {
+					return nil, nil, err
+				}
+				nodes = append(nodes, n)
+			case "endfor", "endif", "elif", "else":
+				return nodes, &seg, nil
+			default:
+				return nil, nil, p.errorf(seg, "unknown tag %q", seg.keyword())
+			}
+		}
+	}
+	return nodes, nil, nil
+}
+
+func (p *templateParser) parseFor(seg segment) (node, error) {
+	toks, err := tokenize(strings.TrimPrefix(seg.text, "for"))
+	if err != nil {
+		return nil, p.errorf(seg, "%s", err)
+	}
+
+	n := &forNode{seg: seg}
+	i := 0
+	for {
+		if toks[i].kind != nameToken || isKeyword(toks[i].val) {
+			return nil, p.errorf(seg, "expected a loop variable in {%% %s %%}", seg.text)
+		}
+		n.targets = append(n.targets, toks[i].val)
+		i++
+		if !toks[i].is(opToken, ",") {
+			break
+		}
+		i++
+	}
+	if len(n.targets) > 2 || !toks[i].is(nameToken, "in") {
+		return nil, p.errorf(seg, "expected {%% for x in expr %%} or {%% for k, v in expr %%}, got {%% %s %%}", seg.text)
+	}
+	if n.iterable, err = parseTokens(toks[i+1:]); err != nil {
+		return nil, p.errorf(seg, "%s", err)
+	}
+
+	var end *segment
+	if n.body, end, err = p.parse(); err != nil {
+		return nil, err
+	}
+	if end != nil && end.keyword() == "else" {
+		if n.elseBody, end, err = p.parse(); err != nil {
+			return nil, err
+		}
+	}
+	if end == nil || end.keyword() != "endfor" {
+		return nil, p.errorf(seg, "{%% %s %%} is not closed by {%% endfor %%}", seg.text)
+	}
+	return n, nil
+}
+
+func (p *templateParser) parseIf(seg segment) (node, error) {
+	n := &ifNode{}
+	branch := seg
+	for {
+		cond, err := parseExpr(strings.TrimPrefix(strings.TrimPrefix(branch.text, branch.keyword()), " "))
+		if err != nil {
+			return nil, p.errorf(branch, "%s", err)
+		}
+		body, end, err := p.parse()
+		if err != nil {
+			return nil, err
+		}
+		n.branches = append(n.branches, ifBranch{seg: branch, cond: cond, body: body})
+
+		if end == nil {
+			return nil, p.errorf(seg, "{%% %s %%} is not closed by {%% endif %%}", seg.text)
+		}
+		switch end.keyword() {
+		case "elif":
+			branch = *end
+			continue
+		case "else":
+			if n.elseBody, end, err = p.parse(); err != nil {
+				return nil, err
+			}
+			if end == nil || end.keyword() != "endif" {
+				return nil, p.errorf(seg, "{%% %s %%} is not closed by {%% endif %%}", seg.text)
+			}
+		case "endif":
+		default:
+			return nil, p.errorf(*end, "unexpected {%% %s %%}", end.keyword())
+		}
+		return n, nil
+	}
+}
+
+func (p *templateParser) parseSet(seg segment) (node, error) {
+	toks, err := tokenize(strings.TrimPrefix(seg.text, "set"))
+	if err != nil {
+		return nil, p.errorf(seg, "%s", err)
+	}
+	if toks[0].kind != nameToken || isKeyword(toks[0].val) || !toks[1].is(opToken, "=") {
+		return nil, p.errorf(seg, "expected {%% set name = expr %%}, got {%% %s %%}", seg.text)
+	}
+	e, err := parseTokens(toks[2:])
+	if err != nil {
+		return nil, p.errorf(seg, "%s", err)
+	}
+	return &setNode{seg: seg, name: toks[0].val, expr: e}, nil
+}
+
+// scope holds the values and the variables set by the template, innermost last
+type scope struct {
+	frames []map[string]interface{}
+}
+
+func (s *scope) lookup(name string) (interface{}, bool) {
+	for i := len(s.frames) - 1; i >= 0; i-- {
+		if v, ok := s.frames[i][name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (s *scope) push(frame map[string]interface{}) {
+	s.frames = append(s.frames, frame)
+}
+
+func (s *scope) pop() {
+	s.frames = s.frames[:len(s.frames)-1]
+}
+
+func renderNodes(name string, nodes []node, s *scope, out *strings.Builder) error {
+	newError := func(seg segment, err error) error {
+		return &Error{Name: name, Line: seg.line, Column: seg.column, Message: err.Error()}
+	}
+
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case *textNode:
+			out.WriteString(n.text)
+
+		case *outputNode:
+			v, err := n.expr.eval(s)
+			if err != nil {
+				return newError(n.seg, err)
+			}
+			if u, ok := v.(undefined); ok {
+				return newError(n.seg, u.err())
+			}
+			out.WriteString(toString(v))
+
+		case *setNode:
+			v, err := n.expr.eval(s)
+			if err != nil {
+				return newError(n.seg, err)
+			}
+			s.frames[len(s.frames)-1][n.name] = v
+
+		case *ifNode:
+			body := n.elseBody
+			for _, b := range n.branches {
+				v, err := b.cond.eval(s)
+				if err != nil {
+					return newError(b.seg, err)
+				}
+				if truthy(v) {
+					body = b.body
+					break
+				}
+			}
+			// as in jinja2, if blocks are no scopes
+			if err := renderNodes(name, body, s, out); err != nil {
+				return err
+			}
+
+		case *forNode:
+			v, err := n.iterable.eval(s)
+			if err != nil {
+				return newError(n.seg, err)
+			}
+			items, err := iterate(v)
+			if err != nil {
+				return newError(n.seg, err)
+			}
+			if len(items) == 0 {
+				s.push(map[string]interface{}{})
+				err := renderNodes(name, n.elseBody, s, out)
+				s.pop()
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			for i, item := range items {
+				frame := map[string]interface{}{
+					"loop": map[string]interface{}{
+						"index":  int64(i + 1),
+						"index0": int64(i),
+						"first":  i == 0,
+						"last":   i == len(items)-1,
+						"length": int64(len(items)),
+					},
+				}
+				if len(n.targets) == 1 {
+					frame[n.targets[0]] = item
+				} else {
+					pair, ok := item.([]interface{})
+					if !ok || len(pair) != 2 {
+						return newError(n.seg, fmt.Errorf("can not unpack %s into %s", typeName(item), strings.Join(n.targets, ", ")))
+					}
+					frame[n.targets[0]], frame[n.targets[1]] = pair[0], pair[1]
+				}
+
+				s.push(frame)
+				err := renderNodes(name, n.body, s, out)
+				s.pop()
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// iterate returns the items of a list, the sorted keys of a map or the
+// characters of a string, undefined values have no items
+func iterate(v interface{}) ([]interface{}, error) {
+	switch v := v.(type) {
+	case undefined:
+		return nil, nil
+	case []interface{}:
+		return v, nil
+	case map[string]interface{}:
+		items := []interface{}{}
+		for _, k := range sortedKeys(v) {
+			items = append(items, k)
+		}
+		return items, nil
+	case string:
+		items := []interface{}{}
+		for _, r := range v {
+			items = append(items, string(r))
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("%s is not iterable", typeName(v))
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
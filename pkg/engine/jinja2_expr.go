@@ -0,0 +1,1091 @@
+package engine
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	nameToken tokenKind = iota
+	numberToken
+	stringToken
+	opToken
+	eofToken
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+}
+
+func (t token) is(kind tokenKind, val string) bool {
+	return t.kind == kind && t.val == val
+}
+
+func (t token) String() string {
+	if t.kind == eofToken {
+		return "end of expression"
+	}
+	return strconv.Quote(t.val)
+}
+
+var keywords = map[string]bool{
+	"and": true, "or": true, "not": true, "in": true, "is": true,
+	"true": true, "false": true, "none": true, "True": true, "False": true, "None": true,
+}
+
+func isKeyword(name string) bool {
+	return keywords[name]
+}
+
+// operators, longest first
+var operators = []string{"==", "!=", "<=", ">=", "<", ">", "(", ")", "[", "]", ".", ",", "|", "~", "+", "-", "=", ":"}
+
+func isNameStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isNamePart(c byte) bool {
+	return isNameStart(c) || c >= '0' && c <= '9'
+}
+
+// tokenize splits an expression into tokens ending with an eofToken. As in
+// Jinja2 a name holds no '-', inputs-01 is inputs minus 01: a key holding one is
+// looked up by subscript, inputs['inputs-01']
+func tokenize(src string) ([]token, error) {
+	toks := []token{}
+	i := 0
+	for i < len(src) {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case isNameStart(c):
+			j := i + 1
+			for j < len(src) && isNamePart(src[j]) {
+				j++
+			}
+			toks = append(toks, token{kind: nameToken, val: src[i:j]})
+			i = j
+
+		case c >= '0' && c <= '9':
+			j := i + 1
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' && j+1 < len(src) && src[j+1] >= '0' && src[j+1] <= '9') {
+				j++
+			}
+			toks = append(toks, token{kind: numberToken, val: src[i:j]})
+			i = j
+
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(src[j])
+					}
+					continue
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("unterminated string %s", src[i:])
+			}
+			toks = append(toks, token{kind: stringToken, val: b.String()})
+			i = j + 1
+
+		default:
+			matched := false
+			for _, op := range operators {
+				if strings.HasPrefix(src[i:], op) {
+					toks = append(toks, token{kind: opToken, val: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+		}
+	}
+	return append(toks, token{kind: eofToken}), nil
+}
+
+// expr is a parsed expression
+type expr interface {
+	eval(s *scope) (interface{}, error)
+}
+
+func parseExpr(src string) (expr, error) {
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	return parseTokens(toks)
+}
+
+func parseTokens(toks []token) (expr, error) {
+	p := &exprParser{toks: toks}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != eofToken {
+		return nil, fmt.Errorf("unexpected %s", p.peek())
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	toks []token
+	pos  int
+}
+
+func (p *exprParser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != eofToken {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) expect(val string) error {
+	if t := p.next(); !t.is(opToken, val) {
+		return fmt.Errorf("expected %q, got %s", val, t)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is(nameToken, "or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is(nameToken, "and") {
+		p.next()
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseNot() (expr, error) {
+	if p.peek().is(nameToken, "not") {
+		p.next()
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{e: e}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (expr, error) {
+	left, err := p.parseMath()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == opToken && (t.val == "==" || t.val == "!=" || t.val == "<" || t.val == ">" || t.val == "<=" || t.val == ">="),
+		t.is(nameToken, "in"):
+		p.next()
+		right, err := p.parseMath()
+		if err != nil {
+			return nil, err
+		}
+		return &compareExpr{op: t.val, left: left, right: right}, nil
+
+	case t.is(nameToken, "not") && p.toks[p.pos+1].is(nameToken, "in"):
+		p.next()
+		p.next()
+		right, err := p.parseMath()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{e: &compareExpr{op: "in", left: left, right: right}}, nil
+
+	case t.is(nameToken, "is"):
+		p.next()
+		negate := false
+		if p.peek().is(nameToken, "not") {
+			p.next()
+			negate = true
+		}
+		test := p.next()
+		if test.kind != nameToken {
+			return nil, fmt.Errorf("expected a test name, got %s", test)
+		}
+		if _, ok := tests[strings.ToLower(test.val)]; !ok {
+			return nil, fmt.Errorf("unknown test %q", test.val)
+		}
+		var e expr = &testExpr{e: left, test: strings.ToLower(test.val)}
+		if negate {
+			e = &notExpr{e: e}
+		}
+		return e, nil
+	}
+
+	return left, nil
+}
+
+// parseMath parses additions and subtractions, which bind looser than ~
+func (p *exprParser) parseMath() (expr, error) {
+	left, err := p.parseConcat()
+	if err != nil {
+		return nil, err
+	}
+	for t := p.peek(); t.is(opToken, "+") || t.is(opToken, "-"); t = p.peek() {
+		p.next()
+		right, err := p.parseConcat()
+		if err != nil {
+			return nil, err
+		}
+		left = &mathExpr{op: t.val, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseConcat() (expr, error) {
+	left, err := p.parseFilter()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is(opToken, "~") {
+		p.next()
+		right, err := p.parseFilter()
+		if err != nil {
+			return nil, err
+		}
+		left = &concatExpr{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseFilter() (expr, error) {
+	e, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().is(opToken, "|") {
+		p.next()
+		name := p.next()
+		if name.kind != nameToken {
+			return nil, fmt.Errorf("expected a filter name, got %s", name)
+		}
+		if _, ok := filters[name.val]; !ok && name.val != "default" && name.val != "d" {
+			return nil, fmt.Errorf("unknown filter %q", name.val)
+		}
+		f := &filterExpr{e: e, name: name.val}
+		if p.peek().is(opToken, "(") {
+			p.next()
+			if f.args, err = p.parseArgs(")"); err != nil {
+				return nil, err
+			}
+		}
+		e = f
+	}
+	return e, nil
+}
+
+// parseUnary parses a signed operand, the filters following it apply to the
+// signed value as in Jinja2: -x | abs is abs(-x)
+func (p *exprParser) parseUnary() (expr, error) {
+	if t := p.peek(); t.is(opToken, "-") || t.is(opToken, "+") {
+		p.next()
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &mathExpr{op: t.val, left: &literalExpr{v: int64(0)}, right: e}, nil
+	}
+	return p.parsePostfix()
+}
+
+// parseArgs parses comma separated expressions up to closing
+func (p *exprParser) parseArgs(closing string) ([]expr, error) {
+	args := []expr{}
+	for !p.peek().is(opToken, closing) {
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if !p.peek().is(opToken, ",") {
+			break
+		}
+		p.next()
+	}
+	return args, p.expect(closing)
+}
+
+func (p *exprParser) parsePostfix() (expr, error) {
+	e, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch t := p.peek(); {
+		case t.is(opToken, "."):
+			p.next()
+			name := p.next()
+			if name.kind != nameToken && name.kind != numberToken {
+				return nil, fmt.Errorf("expected an attribute name, got %s", name)
+			}
+			e = &attrExpr{e: e, name: name.val}
+
+		case t.is(opToken, "["):
+			p.next()
+			key, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			e = &indexExpr{e: e, key: key}
+
+		case t.is(opToken, "("):
+			// items(), keys() and values() are the only methods
+			attr, ok := e.(*attrExpr)
+			if !ok || !mapMethods[attr.name] {
+				return nil, fmt.Errorf("only the items(), keys() and values() methods can be called")
+			}
+			p.next()
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+
+		default:
+			return e, nil
+		}
+	}
+}
+
+func (p *exprParser) parsePrimary() (expr, error) {
+	t := p.next()
+	switch t.kind {
+	case numberToken:
+		if strings.Contains(t.val, ".") {
+			f, err := strconv.ParseFloat(t.val, 64)
+			if err != nil {
+				return nil, err
+			}
+			return &literalExpr{v: f}, nil
+		}
+		i, err := strconv.ParseInt(t.val, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &literalExpr{v: i}, nil
+
+	case stringToken:
+		return &literalExpr{v: t.val}, nil
+
+	case nameToken:
+		switch t.val {
+		case "true", "True":
+			return &literalExpr{v: true}, nil
+		case "false", "False":
+			return &literalExpr{v: false}, nil
+		case "none", "None":
+			return &literalExpr{v: nil}, nil
+		}
+		if isKeyword(t.val) {
+			return nil, fmt.Errorf("unexpected %s", t)
+		}
+		return &nameExpr{name: t.val}, nil
+
+	case opToken:
+		switch t.val {
+		case "(":
+			e, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		case "[":
+			items, err := p.parseArgs("]")
+			if err != nil {
+				return nil, err
+			}
+			return &listExpr{items: items}, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s", t)
+}
+
+// undefined is the value of a name or an attribute missing from the values
+type undefined struct {
+	name string
+}
+
+func (u undefined) err() error {
+	return fmt.Errorf("%q is undefined", u.name)
+}
+
+type literalExpr struct {
+	v interface{}
+}
+
+func (e *literalExpr) eval(*scope) (interface{}, error) {
+	return e.v, nil
+}
+
+type nameExpr struct {
+	name string
+}
+
+func (e *nameExpr) eval(s *scope) (interface{}, error) {
+	if v, ok := s.lookup(e.name); ok {
+		return v, nil
+	}
+	return undefined{name: e.name}, nil
+}
+
+// mapMethods are the methods of mappings, a key of the same name wins
+var mapMethods = map[string]bool{"items": true, "keys": true, "values": true}
+
+type attrExpr struct {
+	e    expr
+	name string
+}
+
+func (e *attrExpr) eval(s *scope) (interface{}, error) {
+	v, err := e.e.eval(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case undefined:
+		return undefined{name: v.name + "." + e.name}, nil
+	case map[string]interface{}:
+		if attr, ok := v[e.name]; ok {
+			return attr, nil
+		}
+		if mapMethods[e.name] {
+			return callMapMethod(v, e.name), nil
+		}
+	case []interface{}:
+		if i, err := strconv.Atoi(e.name); err == nil && i >= 0 && i < len(v) {
+			return v[i], nil
+		}
+	}
+	return undefined{name: fmt.Sprintf("%s.%s", exprName(e.e), e.name)}, nil
+}
+
+func callMapMethod(m map[string]interface{}, method string) []interface{} {
+	out := []interface{}{}
+	for _, k := range sortedKeys(m) {
+		switch method {
+		case "items":
+			out = append(out, []interface{}{k, m[k]})
+		case "keys":
+			out = append(out, k)
+		case "values":
+			out = append(out, m[k])
+		}
+	}
+	return out
+}
+
+// exprName names an expression in the errors about undefined values
+func exprName(e expr) string {
+	switch e := e.(type) {
+	case *nameExpr:
+		return e.name
+	case *attrExpr:
+		return exprName(e.e) + "." + e.name
+	}
+	return "value"
+}
+
+type indexExpr struct {
+	e   expr
+	key expr
+}
+
+func (e *indexExpr) eval(s *scope) (interface{}, error) {
+	v, err := e.e.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	key, err := e.key.eval(s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := v.(type) {
+	case undefined:
+		return undefined{name: fmt.Sprintf("%s[%s]", v.name, pyRepr(key))}, nil
+	case map[string]interface{}:
+		if k, ok := key.(string); ok {
+			if item, ok := v[k]; ok {
+				return item, nil
+			}
+		}
+	case []interface{}:
+		if f, ok := toNumber(key); ok {
+			i := int(f)
+			if i < 0 {
+				i += len(v)
+			}
+			if i >= 0 && i < len(v) {
+				return v[i], nil
+			}
+		}
+	}
+	return undefined{name: fmt.Sprintf("%s[%s]", exprName(e.e), pyRepr(key))}, nil
+}
+
+type listExpr struct {
+	items []expr
+}
+
+func (e *listExpr) eval(s *scope) (interface{}, error) {
+	out := []interface{}{}
+	for _, item := range e.items {
+		v, err := item.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+type logicalExpr struct {
+	op          string
+	left, right expr
+}
+
+// eval returns an operand, as python does
+func (e *logicalExpr) eval(s *scope) (interface{}, error) {
+	left, err := e.left.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(left) == (e.op == "or") {
+		return left, nil
+	}
+	return e.right.eval(s)
+}
+
+type notExpr struct {
+	e expr
+}
+
+func (e *notExpr) eval(s *scope) (interface{}, error) {
+	v, err := e.e.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(v), nil
+}
+
+type compareExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e *compareExpr) eval(s *scope) (interface{}, error) {
+	left, err := e.left.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	right, err := e.right.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	// undefined values compare as none
+	if _, ok := left.(undefined); ok {
+		left = nil
+	}
+	if _, ok := right.(undefined); ok {
+		right = nil
+	}
+
+	switch e.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		return contains(right, left)
+	}
+
+	var c int
+	lf, lok := toNumber(left)
+	rf, rok := toNumber(right)
+	ls, lsok := left.(string)
+	rs, rsok := right.(string)
+	switch {
+	case lok && rok:
+		c = compareFloats(lf, rf)
+	case lsok && rsok:
+		c = strings.Compare(ls, rs)
+	default:
+		return nil, fmt.Errorf("can not compare %s with %s", typeName(left), typeName(right))
+	}
+
+	switch e.op {
+	case "<":
+		return c < 0, nil
+	case ">":
+		return c > 0, nil
+	case "<=":
+		return c <= 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func equal(a, b interface{}) bool {
+	if af, ok := toNumber(a); ok {
+		if bf, ok := toNumber(b); ok {
+			return af == bf
+		}
+		return false
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func contains(container, item interface{}) (bool, error) {
+	switch c := container.(type) {
+	case string:
+		s, ok := item.(string)
+		if !ok {
+			return false, fmt.Errorf("can not look %s up in a string", typeName(item))
+		}
+		return strings.Contains(c, s), nil
+	case []interface{}:
+		for _, v := range c {
+			if equal(v, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case map[string]interface{}:
+		s, ok := item.(string)
+		if !ok {
+			return false, nil
+		}
+		_, found := c[s]
+		return found, nil
+	}
+	return false, fmt.Errorf("can not look a value up in %s", typeName(container))
+}
+
+type concatExpr struct {
+	left, right expr
+}
+
+func (e *concatExpr) eval(s *scope) (interface{}, error) {
+	out := ""
+	for _, operand := range []expr{e.left, e.right} {
+		v, err := operand.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		if u, ok := v.(undefined); ok {
+			return nil, u.err()
+		}
+		out += toString(v)
+	}
+	return out, nil
+}
+
+// mathExpr adds or subtracts numbers, + also joins strings and lists
+type mathExpr struct {
+	op          string
+	left, right expr
+}
+
+func (e *mathExpr) eval(s *scope) (interface{}, error) {
+	operands := make([]interface{}, 2)
+	for i, operand := range []expr{e.left, e.right} {
+		v, err := operand.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		if u, ok := v.(undefined); ok {
+			return nil, u.err()
+		}
+		operands[i] = v
+	}
+	left, right := operands[0], operands[1]
+
+	if e.op == "+" {
+		switch l := left.(type) {
+		case string:
+			if r, ok := right.(string); ok {
+				return l + r, nil
+			}
+		case []interface{}:
+			if r, ok := right.([]interface{}); ok {
+				return append(append([]interface{}{}, l...), r...), nil
+			}
+		}
+	}
+	_, lstr := left.(string)
+	_, rstr := right.(string)
+	l, lok := toNumber(left)
+	r, rok := toNumber(right)
+	if !lok || !rok || lstr || rstr {
+		return nil, fmt.Errorf("unsupported operand types for %s: %s and %s", e.op, typeName(left), typeName(right))
+	}
+	if e.op == "-" {
+		r = -r
+	}
+	if isInteger(left) && isInteger(right) {
+		return int64(l + r), nil
+	}
+	return l + r, nil
+}
+
+// isInteger tells whether v is a python int, bools included
+func isInteger(v interface{}) bool {
+	switch v.(type) {
+	case int, int32, int64, bool:
+		return true
+	}
+	return false
+}
+
+var tests = map[string]func(v interface{}) bool{
+	"defined": func(v interface{}) bool {
+		_, ok := v.(undefined)
+		return !ok
+	},
+	"undefined": func(v interface{}) bool {
+		_, ok := v.(undefined)
+		return ok
+	},
+	"none": func(v interface{}) bool {
+		return v == nil
+	},
+	"string": func(v interface{}) bool {
+		_, ok := v.(string)
+		return ok
+	},
+	"number": func(v interface{}) bool {
+		_, isBool := v.(bool)
+		_, ok := toNumber(v)
+		return ok && !isBool
+	},
+	"mapping": func(v interface{}) bool {
+		_, ok := v.(map[string]interface{})
+		return ok
+	},
+	"sequence": func(v interface{}) bool {
+		switch v.(type) {
+		case []interface{}, string:
+			return true
+		}
+		return false
+	},
+}
+
+type testExpr struct {
+	e    expr
+	test string
+}
+
+func (e *testExpr) eval(s *scope) (interface{}, error) {
+	v, err := e.e.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	return tests[e.test](v), nil
+}
+
+type filterExpr struct {
+	e    expr
+	name string
+	args []expr
+}
+
+func (e *filterExpr) eval(s *scope) (interface{}, error) {
+	v, err := e.e.eval(s)
+	if err != nil {
+		return nil, err
+	}
+	args := []interface{}{}
+	for _, a := range e.args {
+		arg, err := a.eval(s)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	// default(value="", boolean=false) replaces undefined values, and false
+	// values too when boolean is true
+	if e.name == "default" || e.name == "d" {
+		fallback := interface{}("")
+		if len(args) > 0 {
+			fallback = args[0]
+		}
+		_, isUndefined := v.(undefined)
+		if isUndefined || len(args) > 1 && truthy(args[1]) && !truthy(v) {
+			return fallback, nil
+		}
+		return v, nil
+	}
+
+	if u, ok := v.(undefined); ok {
+		return nil, u.err()
+	}
+	out, err := filters[e.name](v, args)
+	if err != nil {
+		return nil, fmt.Errorf("filter %s: %s", e.name, err)
+	}
+	return out, nil
+}
+
+var filters = map[string]func(v interface{}, args []interface{}) (interface{}, error){
+	"upper": func(v interface{}, _ []interface{}) (interface{}, error) {
+		return strings.ToUpper(toString(v)), nil
+	},
+	"lower": func(v interface{}, _ []interface{}) (interface{}, error) {
+		return strings.ToLower(toString(v)), nil
+	},
+	"trim": func(v interface{}, _ []interface{}) (interface{}, error) {
+		return strings.TrimSpace(toString(v)), nil
+	},
+	"string": func(v interface{}, _ []interface{}) (interface{}, error) {
+		return toString(v), nil
+	},
+	"replace": func(v interface{}, args []interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("expected the old and the new string")
+		}
+		return strings.ReplaceAll(toString(v), toString(args[0]), toString(args[1])), nil
+	},
+	"join": func(v interface{}, args []interface{}) (interface{}, error) {
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+		sep := ""
+		if len(args) > 0 {
+			sep = toString(args[0])
+		}
+		parts := []string{}
+		for _, item := range items {
+			parts = append(parts, toString(item))
+		}
+		return strings.Join(parts, sep), nil
+	},
+	"length": length,
+	"count":  length,
+	"first": func(v interface{}, _ []interface{}) (interface{}, error) {
+		items, err := iterate(v)
+		if err != nil || len(items) == 0 {
+			return undefined{name: "first item"}, err
+		}
+		return items[0], nil
+	},
+	"last": func(v interface{}, _ []interface{}) (interface{}, error) {
+		items, err := iterate(v)
+		if err != nil || len(items) == 0 {
+			return undefined{name: "last item"}, err
+		}
+		return items[len(items)-1], nil
+	},
+	"int": func(v interface{}, _ []interface{}) (interface{}, error) {
+		if f, ok := toNumber(v); ok {
+			return int64(f), nil
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSpace(toString(v)), 64); err == nil {
+			return int64(f), nil
+		}
+		return int64(0), nil
+	},
+	"tojson": func(v interface{}, _ []interface{}) (interface{}, error) {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return string(data), nil
+	},
+}
+
+func length(v interface{}, _ []interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return int64(len([]rune(v))), nil
+	case []interface{}:
+		return int64(len(v)), nil
+	case map[string]interface{}:
+		return int64(len(v)), nil
+	}
+	return nil, fmt.Errorf("%s has no length", typeName(v))
+}
+
+// truthy tells whether a value is true, as python does
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil, undefined:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	if f, ok := toNumber(v); ok {
+		return f != 0
+	}
+	return true
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// toString converts a value to a string, as python str does
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case nil:
+		return "None"
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1e15 {
+			return strconv.FormatInt(int64(v), 10)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case map[string]interface{}, []interface{}:
+		return pyRepr(v)
+	}
+	return fmt.Sprint(v)
+}
+
+// pyRepr formats a value as python repr does, lists and mappings formatted
+// this way are YAML flow collections
+func pyRepr(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		quote := "'"
+		if strings.Contains(v, "'") && !strings.Contains(v, `"`) {
+			quote = `"`
+		}
+		r := strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\t", `\t`, quote, `\`+quote)
+		return quote + r.Replace(v) + quote
+	case []interface{}:
+		parts := []string{}
+		for _, item := range v {
+			parts = append(parts, pyRepr(item))
+		}
+		return "[" + strings.Join(parts, ", ") + "]"
+	case map[string]interface{}:
+		parts := []string{}
+		for _, k := range sortedKeys(v) {
+			parts = append(parts, pyRepr(k)+": "+pyRepr(v[k]))
+		}
+		return "{" + strings.Join(parts, ", ") + "}"
+	case undefined:
+		return "Undefined"
+	}
+	return toString(v)
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "none"
+	case undefined:
+		return "undefined"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "a mapping"
+	}
+	if _, ok := toNumber(v); ok {
+		return "a number"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,251 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"sigs.k8s.io/yaml"
+)
+
+func TestJinja2Render(t *testing.T) {
+	values := map[string]interface{}{
+		"instance_name": "daily",
+		"inputs": map[string]interface{}{
+			"inputs-01": map[string]interface{}{"meta": map[string]interface{}{"name": "hcml_lite.bo_sku"}},
+		},
+		"dynamic": map[string]interface{}{"inputs": []interface{}{
+			map[string]interface{}{"name": "a", "conditions": []interface{}{map[string]interface{}{"name": "date", "value": "20221101"}}},
+			map[string]interface{}{"name": "b"},
+		}},
+		"run_conf": map[string]interface{}{
+			"driver": map[string]interface{}{"env": map[string]interface{}{
+				"b": map[string]interface{}{"name": "B", "value": 2.0},
+				"a": map[string]interface{}{"name": "A", "value": true},
+			}},
+			"imagePullSecrets": []interface{}{},
+		},
+		"env": map[string]interface{}{"MY_POD_NAME": "webhook-0"},
+	}
+
+	cases := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"output", "name: salesforecast-{{ instance_name }}", "name: salesforecast-daily"},
+		{"hyphenated keys", "{{ inputs['inputs-01'].meta.name | upper }}", "HCML_LITE.BO_SKU"},
+		{"math", "{{ 3-1 }} {{ dynamic.inputs | length + 1 }} {{ -1.5 + 2 }} {{ 'a' + 'b' }} {{ [1] + [2] }}", "2 3 0.5 ab [1, 2]"},
+		{"default", `{{ run_conf.executor.memory | default("1000Mi") }} {{ run_conf.imagePullSecrets | default("none", true) }}`, "1000Mi none"},
+		{
+			"for",
+			"{% for item in dynamic.inputs %}\n- {{ item.name }}{% for c in item.conditions %} {{ c.name }}={{ c.value }}{% endfor %}\n{% endfor %}",
+			"\n- a date=20221101\n\n- b\n",
+		},
+		{
+			"items with whitespace control",
+			"env:\n{% for key, env in run_conf.driver.env.items() -%}\n- {{ env.name }}: {{ env.value }}\n{% endfor %}",
+			"env:\n- A: True\n- B: 2\n",
+		},
+		{"loop", "{% for x in [1, 2, 3] %}{{ loop.index }}{% if not loop.last %},{% endif %}{% endfor %}", "1,2,3"},
+		{"for else", "{% for s in run_conf.imagePullSecrets %}{{ s }}{% else %}empty{% endfor %}", "empty"},
+		{
+			"if",
+			"{% if run_conf.nodeSelector is defined %}a{% elif run_conf.imagePullSecrets %}b{% else %}c{% endif %}",
+			"c",
+		},
+		{"set", "{% if true %}{% set n = dynamic.inputs | length %}{% endif %}{{ n ~ ' inputs' }}", "2 inputs"},
+		{"comment", "a {# b #}c", "a c"},
+		{"env tag", "name: !ENV ${MY_POD_NAME}\nnamespace: !ENV '${MY_POD_NAMESPACE:default}'", "name: \"webhook-0\"\nnamespace: \"default\""},
+	}
+
+	e := &Jinja2Engine{}
+	for _, c := range cases {
+		out, err := e.Render(c.name, c.template, values)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if out != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, out)
+		}
+	}
+	if _, ok := values["n"]; ok {
+		t.Error("expected set to leave the values untouched")
+	}
+}
+
+func TestJinja2RenderError(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		expected string
+	}{
+		{"undefined", "a: 1\nb: {{ params.data_date }}", "template: undefined:2:4: \"params.data_date\" is undefined"},
+		{"undefined attribute", "{{ env.MISSING }}", "template: undefined attribute:1:1: \"env.MISSING\" is undefined"},
+		{"subtraction", "{{ inputs.inputs-01 }}", "template: subtraction:1:1: \"inputs.inputs\" is undefined"},
+		{"operand types", "{{ 'a' - 1 }}", "template: operand types:1:1: unsupported operand types for -: a string and a number"},
+		{"unclosed", "a\n  {% if x %}\nb", "template: unclosed:2:3: {% if x %} is not closed by {% endif %}"},
+		{"unknown filter", "{{ x | shout }}", "template: unknown filter:1:1: unknown filter \"shout\""},
+		{"env tag", "a: !ENV ${MY_POD_NAMESPACE}", "template: env tag: !ENV variable MY_POD_NAMESPACE is not set in the env values"},
+	}
+
+	e := &Jinja2Engine{}
+	for _, c := range cases {
+		_, err := e.Render(c.name, c.template, map[string]interface{}{"env": map[string]interface{}{}})
+		var tmplErr *Error
+		if !errors.As(err, &tmplErr) {
+			t.Errorf("%s: expected an *Error, got %v", c.name, err)
+			continue
+		}
+		if err.Error() != c.expected {
+			t.Errorf("%s: expected %q, got %q", c.name, c.expected, err)
+		}
+	}
+}
+
+func TestJinja2RenderOperatorDefinitions(t *testing.T) {
+	text, err := os.ReadFile(filepath.Join("..", "..", "charts", "salesforecast", "operator-definitions.yaml.j2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(`
+instance_name: daily
+inputs:
+  inputs-01:
+    conditions:
+      date:
+        value: date = 2022-11-23
+    meta:
+      name: hcml_lite.bo_sku
+      path: /metaxis-6666/warehouse/hcml_lite/bo_sku
+      warehouse: /metaxis-6666/warehouse
+      columns:
+      - name: sku_id
+        dataType: INT
+dynamic:
+  inputs:
+  - name: input-02
+    conditions:
+    - name: date
+      value: date = 2022-11-23
+    meta:
+      id: ""
+      name: hcml_lite.sku_basic
+      path: /metaxis-6666/warehouse/hcml_lite/sku_basic
+      warehouse: /metaxis-6666/warehouse
+      schemas:
+        columns:
+        - name: cate_id
+          dataType: STRING
+outputs:
+  outputs-sku-model:
+    id: ""
+    name: sku_model
+params:
+  data_date: "2022-08-01"
+  future_freq: "13"
+  run_conf:
+    data_date: "2022-08-01"
+    run_date: "2022-08-01"
+    target_freq: W
+    future_freq: "13"
+    model: predict
+run_conf:
+  image:
+    repository: gcr.io/spark-operator/spark-py
+    tag: v3.1.1
+  driver:
+    coreLimit: 1200m
+    serviceAccount: spark
+    env:
+      tz:
+        name: TZ
+        value: Asia/Shanghai
+  executor:
+    image: gcr.io/spark-operator/spark-py:v3.1.1
+    imagePullPolicy: IfNotPresent
+  imagePullSecrets:
+  - value: registry
+env:
+  MY_POD_NAME: webhook-0
+`), &values); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := (&Jinja2Engine{}).Render("operator-definitions.yaml.j2", string(text), values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	od := map[string]interface{}{}
+	if err := yaml.Unmarshal([]byte(out), &od); err != nil {
+		t.Fatalf("rendered definitions are not YAML: %v\n%s", err, out)
+	}
+
+	for path, expected := range map[string]interface{}{
+		"metadata.name":                             "salesforecast-daily",
+		"spec.inputs.0.conditions.0.value":          "date = 2022-11-23",
+		"spec.inputs.0.meta.schemas.columns.0.name": "sku_id",
+		"spec.inputs.1.name":                        "input-02",
+		"spec.inputs.1.conditions.0.value":          "date = 2022-11-23",
+		"spec.inputs.1.meta.schemas.columns.0.name": "cate_id",
+		"spec.outputs.0.name":                       "output-sku-model",
+		"spec.outputs.0.meta.name":                  "sku_model",
+		"spec.params.0.name":                        "run_conf",
+		"spec.params.1.value":                       "2022-08-01",
+		"spec.params.3.value":                       "webhook-0",
+		"spec.params.4.items.0.value":               float64(0),
+		"credentials.s3.0.secretKeyRef.key":         "secretKey",
+		"runtimeConfig.driver.env.0.value":          "Asia/Shanghai",
+		"runtimeConfig.executor.env.0.name":         "TZ",
+		"runtimeConfig.executor.memory":             "1000Mi",
+		"runtimeConfig.executor.imagePullSecrets.0": "registry",
+		"runtimeConfig.mainApplicationFile":         "local:///home/ailake/work/src/LoadNatonDayData.py",
+		"runtimeConfig.mode":                        "cluster",
+		"runtimeConfig.pythonVersion":               "3",
+		"runtimeConfig.restartPolicy.type":          "Never",
+		"runtimeConfig.sparkVersion":                "3.2.1",
+		"runtimeConfig.type":                        "Python",
+	} {
+		if v := lookup(od, path); v != expected {
+			t.Errorf("expected %s to be %v, got %v", path, expected, v)
+		}
+	}
+	if manifest, _ := lookup(od, "spec.params.0.manifest").(string); !strings.Contains(manifest, `"model": "predict"`) {
+		t.Errorf("unexpected run_conf manifest %q", manifest)
+	}
+	if _, ok := lookup(od, "runtimeConfig").(map[string]interface{})["nodeSelector"]; ok {
+		t.Error("expected no nodeSelector without nodeSelector")
+	}
+}
+
+// lookup returns the value at the dotted path of v, list indexes are numbers.
+// The keys of sparkConfiguration hold dots and are looked up whole.
+func lookup(v interface{}, path string) interface{} {
+	if m, ok := v.(map[string]interface{}); ok {
+		if x, ok := m[path]; ok {
+			return x
+		}
+	}
+	head, rest, more := strings.Cut(path, ".")
+	switch x := v.(type) {
+	case map[string]interface{}:
+		v = x[head]
+	case []interface{}:
+		i, err := strconv.Atoi(head)
+		if err != nil || i >= len(x) {
+			return nil
+		}
+		v = x[i]
+	default:
+		return nil
+	}
+	if !more {
+		return v
+	}
+	return lookup(v, rest)
+}
@@ -1,24 +1,10 @@
 package helm
 
 import (
-	"fmt"
-	"log"
-	"os"
-
 	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/chart"
 )
 
-func warning(format string, v ...interface{}) {
-	format = fmt.Sprintf("WARNING: %s\n", format)
-	fmt.Fprintf(os.Stderr, format, v...)
-}
-
-func debug(format string, v ...interface{}) {
-	format = fmt.Sprintf("[debug] %s\n", format)
-	log.Output(2, fmt.Sprintf(format, v...))
-}
-
 func checkIfInstallable(ch *chart.Chart) error {
 	switch ch.Metadata.Type {
 	case "", "application":
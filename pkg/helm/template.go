@@ -1,135 +1,233 @@
 package helm
 
 import (
-	"bytes"
 	"context"
-	"fmt"
-	"io"
-	"log"
 	"os"
+	"regexp"
 	"strings"
 
 	"github.com/pkg/errors"
 	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chart/loader"
-	"helm.sh/helm/v3/pkg/cli"
-	"helm.sh/helm/v3/pkg/cli/values"
-	"helm.sh/helm/v3/pkg/downloader"
-	"helm.sh/helm/v3/pkg/getter"
-	"helm.sh/helm/v3/pkg/release"
+	"sigs.k8s.io/yaml"
+)
 
-	"github.com/allenhaozi/alog"
+const (
+	DefaultReleaseName = "release-name"
+	DefaultNamespace   = "default"
 )
 
-var settings = cli.New()
+var (
+	manifestSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+	sourcePattern     = regexp.MustCompile(`^# Source: (.*)`)
+)
 
-func Template(chartPath, valueFile string) string {
-	os.Setenv("HELM_DRIVER", "configmap")
+// ChartSource locates the chart to render, Chart wins over Path when both are set.
+// Rendering processes the dependencies of the chart in place, so a loaded Chart
+// must not be shared by concurrent renders.
+type ChartSource struct {
+	// Path of a chart directory or packaged chart archive
+	Path string
+	// Chart is an already loaded chart
+	Chart *chart.Chart
+}
 
-	actionConfig := new(action.Configuration)
-	// You can pass an empty string instead of settings.Namespace() to list
-	// all namespaces
-	if err := actionConfig.Init(settings.RESTClientGetter(), settings.Namespace(), os.Getenv("HELM_DRIVER"), log.Printf); err != nil {
-		log.Printf("%+v", err)
-		os.Exit(1)
+func (s ChartSource) String() string {
+	if s.Chart != nil && s.Chart.Metadata != nil {
+		return s.Chart.Metadata.Name
 	}
+	return s.Path
+}
 
-	var validate bool
+// Values are merged on top of the chart defaults in order, value files first and
+// then the in-memory maps, a later source overrides an earlier one
+type Values struct {
+	Files []string
+	Maps  []map[string]interface{}
+}
 
-	client := action.NewInstall(actionConfig)
-	client.DryRun = true
-	client.ReleaseName = "release-name"
-	client.Replace = true // Skip the name check
-	client.ClientOnly = !validate
-	// client.APIVersions = chartutil.VersionSet(extraAPIs)
-	// client.IncludeCRDs = includeCrds
-	valueOpts := &values.Options{
-		ValueFiles: []string{valueFile},
-	}
+// ReleaseOptions describe the release the chart is rendered for
+type ReleaseOptions struct {
+	// Name defaults to DefaultReleaseName
+	Name string
+	// Namespace defaults to DefaultNamespace
+	Namespace string
+	// APIVersions are added to the capabilities seen by the chart templates
+	APIVersions []string
+}
 
-	var release *release.Release
-	var err error
+// Manifest is a single resource rendered by a chart
+type Manifest struct {
+	// Source is the chart template the resource comes from, e.g. salesforecast/templates/sparkapplication.yaml
+	Source     string
+	APIVersion string
+	Kind       string
+	Name       string
+	// Content is the YAML document of the resource
+	Content string
+}
 
-	release, err = runInstall(chartPath, "test", client, valueOpts, os.Stdout)
-	if err != nil {
-		panic(err)
-	}
-	if false {
-		alog.Pretty(release)
-	}
-	// fmt.Println(release.Manifest)
-	var manifests bytes.Buffer
-	fmt.Fprintln(&manifests, strings.TrimSpace(release.Manifest))
-	fmt.Println(manifests.String())
+// Stage is the step of a render a RenderError comes from
+type Stage string
+
+const (
+	StageLoad   Stage = "load"
+	StageValues Stage = "values"
+	StageRender Stage = "render"
+	StageParse  Stage = "parse"
+)
 
-	return manifests.String()
+// RenderError is returned by Render for any failure
+type RenderError struct {
+	Stage Stage
+	Chart string
+	Err   error
 }
 
-func runInstall(cp string, name string, client *action.Install, valueOpts *values.Options, out io.Writer) (*release.Release, error) {
-	debug("Original chart version: %q", client.Version)
-	if client.Version == "" && client.Devel {
-		debug("setting version to >0.0.0-0")
-		client.Version = ">0.0.0-0"
-	}
+func (e *RenderError) Error() string {
+	return "chart " + e.Chart + ": " + string(e.Stage) + ": " + e.Err.Error()
+}
 
-	client.ReleaseName = name
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
 
-	debug("CHART PATH: %s\n", cp)
+// Render renders a chart on the client side, the way `helm template` does, and
+// returns its resources in install order. Hooks and notes are not returned and
+// nothing is installed into the cluster. Render keeps no global state and may
+// be called concurrently.
+func Render(ctx context.Context, source ChartSource, vals Values, opts ReleaseOptions) ([]Manifest, error) {
+	newError := func(stage Stage, err error) error {
+		return &RenderError{Stage: stage, Chart: source.String(), Err: err}
+	}
+
+	chrt := source.Chart
+	if chrt == nil {
+		var err error
+		if chrt, err = loader.Load(source.Path); err != nil {
+			return nil, newError(StageLoad, err)
+		}
+	}
+	if err := checkIfInstallable(chrt); err != nil {
+		return nil, newError(StageLoad, err)
+	}
+	if req := chrt.Metadata.Dependencies; req != nil {
+		if err := action.CheckDependencies(chrt, req); err != nil {
+			return nil, newError(StageLoad, err)
+		}
+	}
 
-	p := getter.All(settings)
-	vals, err := valueOpts.MergeValues(p)
+	values, err := mergeValues(vals)
 	if err != nil {
-		return nil, err
+		return nil, newError(StageValues, err)
+	}
+
+	if opts.Name == "" {
+		opts.Name = DefaultReleaseName
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = DefaultNamespace
 	}
 
-	// Check chart dependencies to make sure all are present in /charts
-	chartRequested, err := loader.Load(cp)
+	client := action.NewInstall(&action.Configuration{Log: func(string, ...interface{}) {}})
+	client.DryRun = true
+	client.ClientOnly = true
+	client.Replace = true // Skip the name check
+	client.ReleaseName = opts.Name
+	client.Namespace = opts.Namespace
+	client.APIVersions = opts.APIVersions
+
+	release, err := client.RunWithContext(ctx, chrt, values)
 	if err != nil {
-		return nil, err
+		return nil, newError(StageRender, err)
 	}
 
-	if err := checkIfInstallable(chartRequested); err != nil {
-		return nil, err
+	manifests, err := SplitManifests(release.Manifest)
+	if err != nil {
+		return nil, newError(StageParse, err)
 	}
 
-	if chartRequested.Metadata.Deprecated {
-		warning("This chart is deprecated")
+	return manifests, nil
+}
+
+// SplitManifests splits a multi document manifest as produced by helm into its
+// resources, empty documents are dropped
+func SplitManifests(manifest string) ([]Manifest, error) {
+	manifests := []Manifest{}
+	for _, doc := range manifestSeparator.Split(manifest, -1) {
+		doc = strings.TrimSpace(doc)
+		if doc == "" {
+			continue
+		}
+
+		m := Manifest{Content: doc}
+		if match := sourcePattern.FindStringSubmatch(doc); match != nil {
+			m.Source = strings.TrimSpace(match[1])
+		}
+
+		head := struct {
+			APIVersion string `json:"apiVersion"`
+			Kind       string `json:"kind"`
+			Metadata   struct {
+				Name string `json:"name"`
+			} `json:"metadata"`
+		}{}
+		if err := yaml.Unmarshal([]byte(doc), &head); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse manifest %s", m.Source)
+		}
+		// a template may render only comments
+		if head.Kind == "" {
+			continue
+		}
+		m.APIVersion = head.APIVersion
+		m.Kind = head.Kind
+		m.Name = head.Metadata.Name
+
+		manifests = append(manifests, m)
 	}
+	return manifests, nil
+}
 
-	if req := chartRequested.Metadata.Dependencies; req != nil {
-		// If CheckDependencies returns an error, we have unfulfilled dependencies.
-		// As of Helm 2.4.0, this is treated as a stopping condition:
-		// https://github.com/helm/helm/issues/2209
-		if err := action.CheckDependencies(chartRequested, req); err != nil {
-			err = errors.Wrap(err, "An error occurred while checking for chart dependencies. You may need to run `helm dependency build` to fetch missing dependencies")
-			if client.DependencyUpdate {
-				man := &downloader.Manager{
-					Out:              out,
-					ChartPath:        cp,
-					Keyring:          client.ChartPathOptions.Keyring,
-					SkipUpdate:       false,
-					Getters:          p,
-					RepositoryConfig: settings.RepositoryConfig,
-					RepositoryCache:  settings.RepositoryCache,
-					Debug:            settings.Debug,
-				}
-				if err := man.Update(); err != nil {
-					return nil, err
-				}
-				// Reload the chart with the updated Chart.lock file.
-				if chartRequested, err = loader.Load(cp); err != nil {
-					return nil, errors.Wrap(err, "failed reloading chart after repo update")
-				}
-			} else {
-				return nil, err
-			}
+func mergeValues(vals Values) (map[string]interface{}, error) {
+	base := map[string]interface{}{}
+
+	for _, filePath := range vals.Files {
+		currentMap := map[string]interface{}{}
+
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read values file %s", filePath)
+		}
+		if err := yaml.Unmarshal(data, &currentMap); err != nil {
+			return nil, errors.Wrapf(err, "failed to parse values file %s", filePath)
 		}
+		base = mergeMaps(base, currentMap)
 	}
 
-	client.Namespace = settings.Namespace()
+	for _, m := range vals.Maps {
+		base = mergeMaps(base, m)
+	}
 
-	// Create context and prepare the handle of SIGTERM
-	ctx := context.Background()
+	return base, nil
+}
 
-	return client.RunWithContext(ctx, chartRequested, vals)
+// mergeMaps returns a new map holding a overridden by b, nested maps are merged
+func mergeMaps(a, b map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(a))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		if v, ok := v.(map[string]interface{}); ok {
+			if bv, ok := out[k]; ok {
+				if bv, ok := bv.(map[string]interface{}); ok {
+					out[k] = mergeMaps(bv, v)
+					continue
+				}
+			}
+		}
+		out[k] = v
+	}
+	return out
 }
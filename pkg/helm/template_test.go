@@ -0,0 +1,57 @@
+package helm
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+var salesforecast = ChartSource{Path: filepath.Join("..", "..", "charts", "salesforecast")}
+
+func TestRender(t *testing.T) {
+	vals := Values{Maps: []map[string]interface{}{{
+		"runtimeConfig": map[string]interface{}{
+			"image": map[string]interface{}{"tag": "v3.2.1"},
+		},
+	}}}
+
+	// renders share nothing, run a few of them at once
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			manifests, err := Render(context.Background(), salesforecast, vals, ReleaseOptions{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(manifests) != 1 {
+				t.Errorf("expected 1 manifest, got %d", len(manifests))
+				return
+			}
+			m := manifests[0]
+			if m.Kind != "SparkApplication" || m.Source != "salesforecast/templates/sparkapplication.yaml" {
+				t.Errorf("unexpected manifest %s from %s", m.Kind, m.Source)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRenderError(t *testing.T) {
+	_, err := Render(context.Background(), ChartSource{Path: "not-found"}, Values{}, ReleaseOptions{})
+
+	var renderErr *RenderError
+	if !errors.As(err, &renderErr) || renderErr.Stage != StageLoad {
+		t.Fatalf("expected a load RenderError, got %v", err)
+	}
+
+	_, err = Render(context.Background(), salesforecast, Values{Files: []string{"not-found.yaml"}}, ReleaseOptions{})
+	if !errors.As(err, &renderErr) || renderErr.Stage != StageValues {
+		t.Fatalf("expected a values RenderError, got %v", err)
+	}
+}
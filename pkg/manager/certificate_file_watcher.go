@@ -0,0 +1,90 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+
+	"github.com/allenhaozi/webhook/api/common"
+)
+
+// CertificateFileWatcher serves the certificate mounted in CertDir, e.g. from a
+// secret issued by cert-manager, and picks up its renewals. Mounted secrets are
+// swapped through symlinks, so the files are polled rather than watched. It runs
+// on every replica.
+type CertificateFileWatcher struct {
+	*CertificateManager
+	// Interval is how often the files are read
+	Interval time.Duration
+}
+
+func NewCertificateFileWatcher(m *CertificateManager, interval time.Duration) *CertificateFileWatcher {
+	w := &CertificateFileWatcher{}
+	w.CertificateManager = m
+	w.Interval = interval
+	return w
+}
+
+// Start implements manager.Runnable
+func (w *CertificateFileWatcher) Start(ctx context.Context) error {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		if err := w.reload(); err != nil {
+			w.Log.Error(err, "reload certificate failure", "dir", w.CertDir)
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (w *CertificateFileWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+// reload serves the certificate of the files when it differs from the current one
+func (w *CertificateFileWatcher) reload() error {
+	next, err := common.ReadCertFileFromLocal(w.CertDir)
+	if err != nil {
+		return errors.Wrap(err, "read certificate file from local failure")
+	}
+
+	current := w.CertContext()
+	if current != nil && bytes.Equal(current.Cert, next.Cert) && bytes.Equal(current.Key, next.Key) &&
+		bytes.Equal(current.CABundle, next.CABundle) {
+		return nil
+	}
+
+	if err := w.serve(apitypes.NamespacedName{}, next); err != nil {
+		return err
+	}
+	w.Log.Info("certificate reloaded", "dir", w.CertDir)
+
+	return nil
+}
+
+// Checker returns a readiness check failing while the certificate served on
+// addr differs from the mounted one
+func (w *CertificateFileWatcher) Checker(addr string) healthz.Checker {
+	return func(_ *http.Request) error {
+		certFile := filepath.Join(w.CertDir, "tls.crt")
+		certPEM, err := os.ReadFile(certFile)
+		if err != nil {
+			return errors.Wrapf(err, "read %s failure", certFile)
+		}
+		return checkServedCert(addr, certPEM, certFile)
+	}
+}
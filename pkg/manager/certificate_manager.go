@@ -1,7 +1,10 @@
 package manager
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"sync"
 
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
@@ -9,6 +12,7 @@ import (
 	kerrors "k8s.io/apimachinery/pkg/api/errors"
 	apitypes "k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 
 	"github.com/allenhaozi/alog"
 	"github.com/allenhaozi/webhook/api/common"
@@ -19,11 +23,25 @@ type CertificateManager struct {
 	CertDir string
 	client.Client
 	Log logr.Logger
+	// ServiceName is the service the certificate is issued for
+	ServiceName string
+	// CertOptions shape the certificates the manager generates
+	CertOptions webhookutils.CertOptions
+
+	mu sync.RWMutex
+	// current is the certificate served and injected into webhook configurations
+	current *common.CertContext
+	// served is the key pair the webhook server presents
+	served    *tls.Certificate
+	secret    apitypes.NamespacedName
+	listeners []chan event.GenericEvent
 }
 
-func NewCertificateManager(c client.Client, l logr.Logger, certDir string) *CertificateManager {
+func NewCertificateManager(c client.Client, l logr.Logger, certDir, serviceName string, opts webhookutils.CertOptions) *CertificateManager {
 	r := &CertificateManager{}
 	r.CertDir = certDir
+	r.ServiceName = serviceName
+	r.CertOptions = opts
 	r.Log = l
 	r.Client = c
 
@@ -45,22 +63,31 @@ func (c *CertificateManager) GenerateCertificate(ns, name string) (*common.CertC
 	// if secret not found
 	if kerrors.IsNotFound(err) {
 		// trigger generate ca logic
-		certContext, err := webhookutils.GenerateCert(objectKey.Namespace, common.WebHookName)
-		if err != nil {
-			c.Log.Error(err, "get namespace environment failure")
+		certContext, genErr := webhookutils.GenerateCert(objectKey.Namespace, c.ServiceName, c.CertOptions)
+		if genErr != nil {
+			return nil, errors.Wrap(genErr, "generate certificate failure")
 		}
-		// write certificate file to local directory
-		if err := certContext.WriteCertFileToLocal(c.CertDir); err != nil {
-			c.Log.Error(err, "write certificate file to local failure")
+		// persist certificate to secret, replicas starting together race for
+		// it: only the first create succeeds and the others adopt its certificate
+		created := certContext.ComposeSecrets(objectKey.Namespace, objectKey.Name)
+		err = c.Create(ctx, created)
+		if err == nil {
+			// the CA key, needed to renew the certificate, is kept apart
+			if err := c.storeCASecret(ctx, objectKey, certContext); err != nil {
+				return nil, err
+			}
+			// write certificate file to local directory
+			if err := c.install(objectKey, certContext); err != nil {
+				return nil, err
+			}
+			return certContext, nil
 		}
-		// persist certificate to secret
-		secret := certContext.ComposeSecrets(objectKey.Namespace, objectKey.Name)
-		// TODO: use patch
-		// if err := c.Create(ctx, secret); err != nil {
-		if err := c.Patch(ctx, secret, client.Apply); err != nil {
+		if !kerrors.IsAlreadyExists(err) {
 			return nil, errors.Wrap(err, "create secret failure")
 		}
-		return certContext, nil
+
+		c.Log.Info("certificate secret created by another replica, adopt it", "secret", objectKey)
+		err = c.Get(ctx, objectKey, secret)
 	}
 
 	if err != nil {
@@ -72,11 +99,184 @@ func (c *CertificateManager) GenerateCertificate(ns, name string) (*common.CertC
 	if err != nil {
 		return nil, errors.Wrap(err, "parse secret to certificate failure")
 	}
+	c.loadSigningKey(ctx, objectKey, certContext)
 
 	// generate local certificate
-	if err := certContext.WriteCertFileToLocal(c.CertDir); err != nil {
-		return nil, errors.Wrap(err, "write certificate file to local failure")
+	if err := c.install(objectKey, certContext); err != nil {
+		return nil, err
+	}
+
+	return certContext, nil
+}
+
+func caSecretKey(secret apitypes.NamespacedName) apitypes.NamespacedName {
+	return apitypes.NamespacedName{Namespace: secret.Namespace, Name: secret.Name + common.CASecretSuffix}
+}
+
+// storeCASecret creates or updates the secret holding the CA key of the certificate secret
+func (c *CertificateManager) storeCASecret(ctx context.Context, secret apitypes.NamespacedName, certContext *common.CertContext) error {
+	key := caSecretKey(secret)
+	desired := certContext.ComposeCASecret(key.Namespace, key.Name)
+
+	existing := &corev1.Secret{}
+	err := c.Get(ctx, key, existing)
+	if kerrors.IsNotFound(err) {
+		if err := c.Create(ctx, desired); err != nil {
+			return errors.Wrapf(err, "create secret %s failure", key)
+		}
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "get secret %s failure", key)
+	}
+
+	if bytes.Equal(existing.Data["tls.crt"], desired.Data["tls.crt"]) && bytes.Equal(existing.Data["tls.key"], desired.Data["tls.key"]) {
+		return nil
+	}
+	existing.Labels = desired.Labels
+	existing.Annotations = desired.Annotations
+	existing.Data = desired.Data
+	if err := c.Update(ctx, existing); err != nil {
+		return errors.Wrapf(err, "update secret %s failure", key)
+	}
+	return nil
+}
+
+// loadSigningKey sets the CA key of the certificate secret on certContext, a
+// missing or mismatching CA secret leaves it empty: the certificate then can't
+// be renewed and a new CA is created on rotation
+func (c *CertificateManager) loadSigningKey(ctx context.Context, secret apitypes.NamespacedName, certContext *common.CertContext) {
+	key := caSecretKey(secret)
+	caSecret := &corev1.Secret{}
+	if err := c.Get(ctx, key, caSecret); err != nil {
+		c.Log.Info("CA key not loaded", "secret", key, "reason", err.Error())
+		return
+	}
+	if err := certContext.SetSigningKeyBySecret(caSecret); err != nil {
+		c.Log.Info("CA key not loaded", "secret", key, "reason", err.Error())
+	}
+}
+
+// LoadCertificateFromSecret serves the certificate of an existing secret, managed
+// by cert-manager or by hand, the secret is never written
+func (c *CertificateManager) LoadCertificateFromSecret(ns, name string) (*common.CertContext, error) {
+	objectKey := apitypes.NamespacedName{
+		Namespace: ns,
+		Name:      name,
+	}
+	secret := &corev1.Secret{}
+	if err := c.Get(context.Background(), objectKey, secret); err != nil {
+		return nil, errors.Wrapf(err, "get secret %s failure", objectKey)
+	}
+
+	certContext, err := common.GenerateCertBySecret(secret)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse secret to certificate failure")
+	}
+	if err := c.install(objectKey, certContext); err != nil {
+		return nil, err
 	}
 
 	return certContext, nil
 }
+
+// LoadCertificateFromFiles serves the certificate mounted in CertDir, the files are never written
+func (c *CertificateManager) LoadCertificateFromFiles() (*common.CertContext, error) {
+	certContext, err := common.ReadCertFileFromLocal(c.CertDir)
+	if err != nil {
+		return nil, errors.Wrap(err, "read certificate file from local failure")
+	}
+	if err := c.serve(apitypes.NamespacedName{}, certContext); err != nil {
+		return nil, err
+	}
+
+	return certContext, nil
+}
+
+// install writes the certificate to CertDir, serves it and makes it current
+func (c *CertificateManager) install(secret apitypes.NamespacedName, certContext *common.CertContext) error {
+	if err := certContext.WriteCertFileToLocal(c.CertDir); err != nil {
+		return errors.Wrap(err, "write certificate file to local failure")
+	}
+	return c.serve(secret, certContext)
+}
+
+// serve makes the certificate current and has the webhook server present it
+func (c *CertificateManager) serve(secret apitypes.NamespacedName, certContext *common.CertContext) error {
+	served, err := tls.X509KeyPair(certContext.Cert, certContext.Key)
+	if err != nil {
+		return errors.Wrap(err, "load certificate key pair failure")
+	}
+
+	c.mu.Lock()
+	c.served = &served
+	c.mu.Unlock()
+
+	c.setCertContext(secret, certContext)
+	return nil
+}
+
+// GetCertificate returns the key pair the webhook server presents, it is meant
+// for tls.Config.GetCertificate so a new certificate is served without a restart
+func (c *CertificateManager) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.served == nil {
+		return nil, errors.New("no certificate loaded")
+	}
+	return c.served, nil
+}
+
+// CertContext returns the current certificate
+func (c *CertificateManager) CertContext() *common.CertContext {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.current
+}
+
+// Subscribe returns a channel receiving an event, carrying the certificate
+// secret, each time the current certificate changes
+func (c *CertificateManager) Subscribe() <-chan event.GenericEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan event.GenericEvent, 1)
+	c.listeners = append(c.listeners, ch)
+	return ch
+}
+
+func (c *CertificateManager) secretKey() apitypes.NamespacedName {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.secret
+}
+
+func (c *CertificateManager) setCertContext(secret apitypes.NamespacedName, certContext *common.CertContext) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current = certContext
+	c.secret = secret
+
+	s := &corev1.Secret{}
+	s.Name = secret.Name
+	s.Namespace = secret.Namespace
+	for _, ch := range c.listeners {
+		// a pending event already makes the listener pick up the latest certificate
+		select {
+		case ch <- event.GenericEvent{Object: s}:
+		default:
+		}
+	}
+}
+
+// CertMode tells where the webhook certificate comes from
+type CertMode string
+
+const (
+	// CertModeSelfSigned generates a self-signed certificate, stores it in a
+	// secret and rotates it
+	CertModeSelfSigned CertMode = "self-signed"
+	// CertModeSecret reads an existing secret, e.g. issued by cert-manager
+	CertModeSecret CertMode = "secret"
+	// CertModeFiles trusts the certificate mounted in the certificate directory
+	CertModeFiles CertMode = "files"
+)
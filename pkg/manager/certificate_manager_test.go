@@ -0,0 +1,102 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	webhookutils "github.com/allenhaozi/webhook/pkg/utils"
+)
+
+// staleClient misses the secret on its first read, as a replica racing another one does
+type staleClient struct {
+	client.Client
+	missed bool
+}
+
+func (c *staleClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	if !c.missed {
+		c.missed = true
+		return kerrors.NewNotFound(corev1.Resource("secrets"), key.Name)
+	}
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func TestGenerateCertificateAdoptsExistingSecret(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	// the secret of the replica winning the race
+	winner, err := webhookutils.GenerateCert("default", "webhook-service", webhookutils.CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(winner.ComposeSecrets("default", "webhook-service")).Build()
+
+	m := NewCertificateManager(&staleClient{Client: c}, logr.Discard(), t.TempDir(), "webhook-service", webhookutils.CertOptions{})
+	got, err := m.GenerateCertificate("default", "webhook-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Cert, winner.Cert) || !bytes.Equal(m.CertContext().CABundle, winner.CABundle) {
+		t.Error("certificate of the existing secret not adopted")
+	}
+
+	secret := &corev1.Secret{}
+	if err := c.Get(context.Background(), apitypes.NamespacedName{Namespace: "default", Name: "webhook-service"}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret.Data["tls.crt"], winner.Cert) {
+		t.Error("secret of the winner overwritten")
+	}
+}
+
+func TestGenerateCertificateCreatesSecret(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+
+	m := NewCertificateManager(c, logr.Discard(), t.TempDir(), "webhook-service", webhookutils.CertOptions{})
+	got, err := m.GenerateCertificate("default", "webhook-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret := &corev1.Secret{}
+	if err := c.Get(context.Background(), apitypes.NamespacedName{Namespace: "default", Name: "webhook-service"}, secret); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(secret.Data["tls.crt"], got.Cert) || secret.Type != corev1.SecretTypeTLS {
+		t.Error("generated certificate not stored")
+	}
+
+	caSecret := &corev1.Secret{}
+	if err := c.Get(context.Background(), apitypes.NamespacedName{Namespace: "default", Name: "webhook-service-ca"}, caSecret); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(caSecret.Data["tls.key"], got.SigningKey) {
+		t.Error("CA key not stored")
+	}
+
+	// a restarted manager loads the CA key back
+	restarted := NewCertificateManager(c, logr.Discard(), t.TempDir(), "webhook-service", webhookutils.CertOptions{})
+	loaded, err := restarted.GenerateCertificate("default", "webhook-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.SigningKey, got.SigningKey) {
+		t.Error("CA key not loaded")
+	}
+}
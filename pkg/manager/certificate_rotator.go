@@ -0,0 +1,218 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/allenhaozi/webhook/api/common"
+	webhookutils "github.com/allenhaozi/webhook/pkg/utils"
+)
+
+// CertificateRotator regenerates the webhook certificate of a CertificateManager
+// when it is about to expire or doesn't match the service DNS name. It runs on
+// the leader only.
+type CertificateRotator struct {
+	*CertificateManager
+	Namespace   string
+	ServiceName string
+	// RotateBefore is how long before expiry the certificate is rotated
+	RotateBefore time.Duration
+	// CheckInterval is how often the certificate is checked
+	CheckInterval time.Duration
+	// BundlePropagation is how long the CA bundle trusting a new CA is given to
+	// reach the webhook configurations before a certificate of that CA is served
+	BundlePropagation time.Duration
+}
+
+func NewCertificateRotator(m *CertificateManager, ns, serviceName string, rotateBefore, checkInterval time.Duration) *CertificateRotator {
+	r := &CertificateRotator{}
+	r.CertificateManager = m
+	r.Namespace = ns
+	r.ServiceName = serviceName
+	r.RotateBefore = rotateBefore
+	r.CheckInterval = checkInterval
+	r.BundlePropagation = 30 * time.Second
+	return r
+}
+
+// Start implements manager.Runnable
+func (r *CertificateRotator) Start(ctx context.Context) error {
+	ticker := time.NewTicker(r.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.rotateIfNeeded(ctx); err != nil {
+			r.Log.Error(err, "rotate certificate failure")
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (r *CertificateRotator) NeedLeaderElection() bool {
+	return true
+}
+
+func (r *CertificateRotator) rotateIfNeeded(ctx context.Context) error {
+	current := r.CertContext()
+	if current == nil {
+		return errors.New("no certificate to rotate")
+	}
+
+	reason, err := r.rotationReason(current, time.Now())
+	if err != nil {
+		return err
+	}
+	if reason == "" {
+		return nil
+	}
+	r.Log.Info("rotate certificate", "reason", reason)
+
+	if len(current.SigningKey) == 0 {
+		// a replica which became leader after a rotation didn't load the CA key
+		withKey := *current
+		r.loadSigningKey(ctx, r.secretKey(), &withKey)
+		current = &withKey
+	}
+
+	next, err := r.renew(current, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(next.SigningCert, current.SigningCert) {
+		// trust the new CA alongside the old one while the old certificate is still served
+		interim := *current
+		interim.CABundle = next.CABundle
+		r.setCertContext(r.secretKey(), &interim)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.BundlePropagation):
+		}
+	}
+
+	return r.store(ctx, next)
+}
+
+// rotationReason tells why the certificate needs a rotation, empty when it doesn't
+func (r *CertificateRotator) rotationReason(c *common.CertContext, now time.Time) (string, error) {
+	dnsNames := append(webhookutils.ServiceDNSNames(r.Namespace, r.ServiceName), r.CertOptions.DNSNames...)
+	covered, err := webhookutils.CertCovers(c.Cert, dnsNames, r.CertOptions.IPs)
+	if err != nil {
+		return "", errors.Wrap(err, "parse serving certificate failure")
+	}
+	if !covered {
+		return "certificate doesn't cover the configured DNS names and IP addresses", nil
+	}
+
+	algorithm, err := webhookutils.CertKeyAlgorithm(c.Cert)
+	if err != nil {
+		return "", errors.Wrap(err, "parse serving certificate failure")
+	}
+	if want := r.keyAlgorithm(); algorithm != want {
+		return "certificate key algorithm " + string(algorithm) + " isn't " + string(want), nil
+	}
+
+	for _, item := range []struct {
+		name    string
+		certPEM []byte
+	}{{"certificate", c.Cert}, {"CA", c.SigningCert}} {
+		notAfter, err := webhookutils.CertExpiry(item.certPEM)
+		if err != nil {
+			return "", errors.Wrapf(err, "parse %s failure", item.name)
+		}
+		if notAfter.Sub(now) < r.RotateBefore {
+			return item.name + " expires at " + notAfter.String(), nil
+		}
+	}
+
+	return "", nil
+}
+
+func (r *CertificateRotator) keyAlgorithm() webhookutils.KeyAlgorithm {
+	if r.CertOptions.KeyAlgorithm == "" {
+		return webhookutils.KeyAlgorithmRSA
+	}
+	return r.CertOptions.KeyAlgorithm
+}
+
+// renew reuses the CA when its key is known and it stays valid long enough,
+// otherwise a new CA is created and the old one kept in the bundle until it expires.
+// A reused CA keeps the bundle, less the CAs which expired since.
+func (r *CertificateRotator) renew(c *common.CertContext, now time.Time) (*common.CertContext, error) {
+	caNotAfter, err := webhookutils.CertExpiry(c.SigningCert)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse CA failure")
+	}
+
+	if len(c.SigningKey) > 0 && caNotAfter.Sub(now) >= r.RotateBefore {
+		next, err := webhookutils.RenewCert(r.Namespace, r.ServiceName, c.SigningCert, c.SigningKey, r.CertOptions)
+		if err != nil {
+			return nil, errors.Wrap(err, "renew certificate failure")
+		}
+		if next.CABundle, err = webhookutils.TrimExpiredCerts(c.CABundle, now); err != nil {
+			return nil, errors.Wrap(err, "parse CA bundle failure")
+		}
+		return next, nil
+	}
+
+	next, err := webhookutils.GenerateCert(r.Namespace, r.ServiceName, r.CertOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate certificate failure")
+	}
+	if caNotAfter.After(now) {
+		next.CABundle = append(append([]byte{}, next.SigningCert...), c.SigningCert...)
+	}
+	return next, nil
+}
+
+// store persists the certificate to its secret and the local certificate directory
+func (r *CertificateRotator) store(ctx context.Context, c *common.CertContext) error {
+	secretKey := r.secretKey()
+
+	// the CA key goes first, the certificate secret must never refer to a CA whose key is lost
+	if err := r.storeCASecret(ctx, secretKey, c); err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, secretKey, secret); err != nil {
+		return errors.Wrapf(err, "get secret %s failure", secretKey)
+	}
+	// the type of a secret is immutable, a secret created Opaque stays Opaque
+	desired := c.ComposeSecrets(secretKey.Namespace, secretKey.Name)
+	if secret.Labels == nil {
+		secret.Labels = map[string]string{}
+	}
+	for k, v := range desired.Labels {
+		secret.Labels[k] = v
+	}
+	if secret.Annotations == nil {
+		secret.Annotations = map[string]string{}
+	}
+	for k, v := range desired.Annotations {
+		secret.Annotations[k] = v
+	}
+	secret.Data = desired.Data
+	if err := r.Update(ctx, secret); err != nil {
+		return errors.Wrapf(err, "update secret %s failure", secretKey)
+	}
+
+	if err := r.install(secretKey, c); err != nil {
+		return err
+	}
+	r.Log.Info("certificate rotated", "secret", secretKey)
+
+	return nil
+}
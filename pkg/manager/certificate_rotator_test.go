@@ -0,0 +1,80 @@
+package manager
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	webhookutils "github.com/allenhaozi/webhook/pkg/utils"
+)
+
+func TestCertificateRotatorRenew(t *testing.T) {
+	current, err := webhookutils.GenerateCert("default", "webhook-service", webhookutils.CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewCertificateRotator(&CertificateManager{}, "default", "webhook-service", 24*time.Hour, time.Hour)
+	now := time.Now()
+
+	reason, err := r.rotationReason(current, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reason != "" {
+		t.Errorf("fresh certificate rotated: %s", reason)
+	}
+
+	expiring := now.Add(10*365*24*time.Hour - time.Hour)
+	if reason, _ := r.rotationReason(current, expiring); reason == "" {
+		t.Error("expiring certificate not rotated")
+	}
+
+	// the CA stays valid long enough, it is reused
+	next, err := r.renew(current, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(next.SigningCert, current.SigningCert) || !bytes.Equal(next.CABundle, current.CABundle) {
+		t.Error("CA not reused")
+	}
+	if bytes.Equal(next.Cert, current.Cert) {
+		t.Error("certificate not renewed")
+	}
+
+	// the CA expires within RotateBefore, a new CA is trusted next to the old one
+	next, err = r.renew(current, expiring)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(next.SigningCert, current.SigningCert) {
+		t.Error("CA not renewed")
+	}
+	if !bytes.Contains(next.CABundle, next.SigningCert) || !bytes.Contains(next.CABundle, current.SigningCert) {
+		t.Error("CA bundle doesn't hold both CAs")
+	}
+
+	// the CAs expired since are trimmed from the bundle of a reused CA
+	previous, err := webhookutils.GenerateCert("default", "webhook-service", webhookutils.CertOptions{CAValidity: 48 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	withPrevious := *current
+	withPrevious.CABundle = append(append([]byte{}, current.SigningCert...), previous.SigningCert...)
+	next, err = r.renew(&withPrevious, now.Add(72*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(next.CABundle, current.SigningCert) {
+		t.Error("expired CA kept in the bundle")
+	}
+	if next, _ = r.renew(&withPrevious, now); !bytes.Equal(next.CABundle, withPrevious.CABundle) {
+		t.Error("valid CA trimmed from the bundle")
+	}
+
+	// a certificate for another service is rotated
+	r.ServiceName = "other-service"
+	if reason, _ := r.rotationReason(current, now); reason == "" {
+		t.Error("mismatched certificate not rotated")
+	}
+}
@@ -0,0 +1,151 @@
+package manager
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	apitypes "k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+
+	"github.com/allenhaozi/webhook/api/common"
+)
+
+// CertificateWatcher keeps the served certificate in sync with the certificate
+// secret, a certificate rotated by the leader or replaced by hand is written
+// to CertDir and served without a restart. It runs on every replica.
+type CertificateWatcher struct {
+	*CertificateManager
+	Secret apitypes.NamespacedName
+	// cache holds the certificate secret only
+	cache cache.Cache
+}
+
+func NewCertificateWatcher(m *CertificateManager, config *rest.Config, scheme *runtime.Scheme, ns, name string) (*CertificateWatcher, error) {
+	c, err := cache.New(config, cache.Options{
+		Scheme:    scheme,
+		Namespace: ns,
+		SelectorsByObject: cache.SelectorsByObject{
+			&corev1.Secret{}: {Field: fields.OneTermEqualSelector("metadata.name", name)},
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "create certificate secret cache failure")
+	}
+
+	w := &CertificateWatcher{}
+	w.CertificateManager = m
+	w.Secret = apitypes.NamespacedName{Namespace: ns, Name: name}
+	w.cache = c
+	return w, nil
+}
+
+// Start implements manager.Runnable
+func (w *CertificateWatcher) Start(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- w.cache.Start(ctx)
+	}()
+
+	informer, err := w.cache.GetInformer(ctx, &corev1.Secret{})
+	if err != nil {
+		return errors.Wrap(err, "get certificate secret informer failure")
+	}
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    w.onSecret,
+		UpdateFunc: func(_, obj interface{}) { w.onSecret(obj) },
+	})
+
+	return <-errCh
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable
+func (w *CertificateWatcher) NeedLeaderElection() bool {
+	return false
+}
+
+func (w *CertificateWatcher) onSecret(obj interface{}) {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok || secret.Name != w.Secret.Name || secret.Namespace != w.Secret.Namespace {
+		return
+	}
+
+	if err := w.reload(secret); err != nil {
+		w.Log.Error(err, "reload certificate failure", "secret", w.Secret)
+	}
+}
+
+// reload serves the certificate of the secret when it differs from the current one
+func (w *CertificateWatcher) reload(secret *corev1.Secret) error {
+	next, err := common.GenerateCertBySecret(secret)
+	if err != nil {
+		return errors.Wrap(err, "parse secret to certificate failure")
+	}
+
+	current := w.CertContext()
+	if current != nil {
+		if bytes.Equal(current.Cert, next.Cert) && bytes.Equal(current.Key, next.Key) && bytes.Equal(current.CABundle, next.CABundle) {
+			return nil
+		}
+		// the secret doesn't hold the CA key, keep it while the CA is unchanged
+		if bytes.Equal(current.SigningCert, next.SigningCert) {
+			next.SigningKey = current.SigningKey
+		}
+	}
+
+	if err := w.install(w.Secret, next); err != nil {
+		return err
+	}
+	w.Log.Info("certificate reloaded", "secret", w.Secret)
+
+	return nil
+}
+
+// Checker returns a readiness check failing while the certificate served on
+// addr differs from the one in the certificate secret
+func (w *CertificateWatcher) Checker(addr string) healthz.Checker {
+	return func(req *http.Request) error {
+		secret := &corev1.Secret{}
+		if err := w.cache.Get(req.Context(), w.Secret, secret); err != nil {
+			return errors.Wrapf(err, "get secret %s failure", w.Secret)
+		}
+		return checkServedCert(addr, secret.Data["tls.crt"], "secret "+w.Secret.String())
+	}
+}
+
+// checkServedCert fails when the certificate served on addr isn't the first
+// certificate of certPEM, read from source
+func checkServedCert(addr string, certPEM []byte, source string) error {
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return errors.Errorf("%s holds no certificate", source)
+	}
+
+	config := &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec // only the presented certificate is compared
+	}
+	d := &net.Dialer{Timeout: 10 * time.Second}
+	conn, err := tls.DialWithDialer(d, "tcp", addr, config)
+	if err != nil {
+		return errors.Wrap(err, "webhook server is not reachable")
+	}
+	defer conn.Close()
+
+	peers := conn.ConnectionState().PeerCertificates
+	if len(peers) == 0 || !bytes.Equal(peers[0].Raw, block.Bytes) {
+		return errors.Errorf("served certificate differs from %s", source)
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package manager
+
+import (
+	"bytes"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	apitypes "k8s.io/apimachinery/pkg/types"
+
+	webhookutils "github.com/allenhaozi/webhook/pkg/utils"
+)
+
+func TestCertificateWatcherReload(t *testing.T) {
+	certDir := t.TempDir()
+	w := &CertificateWatcher{
+		CertificateManager: NewCertificateManager(nil, logr.Discard(), certDir, "webhook-service", webhookutils.CertOptions{}),
+		Secret:             apitypes.NamespacedName{Namespace: "default", Name: "webhook-service"},
+	}
+
+	first, err := webhookutils.GenerateCert("default", "webhook-service", webhookutils.CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.install(w.Secret, first); err != nil {
+		t.Fatal(err)
+	}
+	events := w.Subscribe()
+
+	// the secret is updated with a certificate of the same CA
+	next, err := webhookutils.RenewCert("default", "webhook-service", first.SigningCert, first.SigningKey, webhookutils.CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next.CABundle = first.CABundle
+	if err := w.reload(next.ComposeSecrets("default", "webhook-service")); err != nil {
+		t.Fatal(err)
+	}
+
+	served, err := w.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	crt, err := os.ReadFile(filepath.Join(certDir, "tls.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(crt, next.Cert) {
+		t.Error("certificate file not rewritten")
+	}
+	if block, _ := pem.Decode(next.Cert); !bytes.Equal(block.Bytes, served.Certificate[0]) {
+		t.Error("new certificate not served")
+	}
+	if !bytes.Equal(w.CertContext().SigningKey, first.SigningKey) {
+		t.Error("CA key of an unchanged CA dropped")
+	}
+	select {
+	case <-events:
+	default:
+		t.Error("certificate change not notified")
+	}
+
+	// an unchanged secret is ignored
+	if err := w.reload(next.ComposeSecrets("default", "webhook-service")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-events:
+		t.Error("unchanged certificate notified")
+	default:
+	}
+}
+
+func TestCertificateFileWatcherReload(t *testing.T) {
+	certDir := t.TempDir()
+	first, err := webhookutils.GenerateCert("default", "webhook-service", webhookutils.CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.WriteCertFileToLocal(certDir); err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewCertificateFileWatcher(NewCertificateManager(nil, logr.Discard(), certDir, "webhook-service", webhookutils.CertOptions{}), time.Minute)
+	if _, err := w.LoadCertificateFromFiles(); err != nil {
+		t.Fatal(err)
+	}
+	events := w.Subscribe()
+
+	// the mounted certificate is renewed
+	next, err := webhookutils.GenerateCert("default", "webhook-service", webhookutils.CertOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := next.WriteCertFileToLocal(certDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	served, err := w.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block, _ := pem.Decode(next.Cert); !bytes.Equal(block.Bytes, served.Certificate[0]) {
+		t.Error("renewed certificate not served")
+	}
+	if !bytes.Equal(w.CertContext().CABundle, next.CABundle) {
+		t.Error("CA bundle of the renewed certificate not current")
+	}
+	select {
+	case <-events:
+	default:
+		t.Error("certificate change not notified")
+	}
+}
@@ -0,0 +1,163 @@
+// Package spark adds to the SparkApplications of the spark operator what the
+// charts rendering them leave out.
+package spark
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	openaiosv1alpha1 "github.com/allenhaozi/webhook/api/openaios/v1alpha1"
+)
+
+// keys of the KerberosSecret of a HDFS credential
+const (
+	KeytabKey   = "keytab"
+	Krb5ConfKey = "krb5.conf"
+)
+
+// secretTypeGeneric mounts a secret without further handling by the spark operator
+const secretTypeGeneric = "Generic"
+
+// podSpecs are the pods of a SparkApplication the credentials are injected into
+var podSpecs = []string{"driver", "executor"}
+
+// InjectCredentials adds the credentials held by Secrets to the driver and the
+// executor of the SparkApplication app, so no secret shows in plain text:
+//   - the keys an S3 credential references are set from envSecretKeyRefs as
+//     <NAME>_ACCESS_KEY and <NAME>_SECRET_KEY, its endpoint as <NAME>_ENDPOINT,
+//     NAME being the credential name upper cased with - and . turned into _
+//   - the HadoopConfigurationSecret of a HDFS credential is mounted at its
+//     hadoopConfigurationDir, which HADOOP_CONF_DIR points at
+//   - the KerberosSecret of a HDFS credential is mounted at its
+//     kerberosConfigurationDir, KRB5_CONFIG and spark.kubernetes.kerberos.krb5.path
+//     point at its krb5.conf and spark.kerberos.keytab at its keytab
+//
+// Credentials given in plain text are left out, see
+// openaiosv1alpha1.ValidateCredentials rejecting them. Environment variables and
+// sparkConf entries already set, e.g. by the first of several HDFS
+// credentials, are kept.
+func InjectCredentials(app map[string]interface{}, creds *openaiosv1alpha1.Credentials) error {
+	if creds == nil {
+		return nil
+	}
+
+	for _, s3 := range creds.S3 {
+		prefix := envName(s3.Name)
+		for _, pod := range podSpecs {
+			if s3.Endpoint != "" {
+				if err := setDefault(app, s3.Endpoint, "spec", pod, "envVars", prefix+"_ENDPOINT"); err != nil {
+					return err
+				}
+			}
+			if ref := s3.AccessKeyRef; ref != nil {
+				if err := setSecretKeyRef(app, pod, prefix+"_ACCESS_KEY", ref.Name, ref.Key); err != nil {
+					return err
+				}
+			}
+			if ref := s3.SecretKeyRef; ref != nil {
+				if err := setSecretKeyRef(app, pod, prefix+"_SECRET_KEY", ref.Name, ref.Key); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, hdfs := range creds.HDFS {
+		if hdfs.HadoopConfigurationSecret != "" {
+			if hdfs.HadoopConfigurationDir == "" {
+				return errors.Errorf("hdfs credential %s mounts secret %s without hadoopConfigurationDir", hdfs.Name, hdfs.HadoopConfigurationSecret)
+			}
+			env := map[string]string{"HADOOP_CONF_DIR": hdfs.HadoopConfigurationDir}
+			if err := mountSecret(app, hdfs.HadoopConfigurationSecret, hdfs.HadoopConfigurationDir, env); err != nil {
+				return err
+			}
+		}
+
+		if hdfs.KerberosSecret != "" {
+			dir := hdfs.KerberosConfigurationDir
+			if dir == "" {
+				return errors.Errorf("hdfs credential %s mounts secret %s without kerberosConfigurationDir", hdfs.Name, hdfs.KerberosSecret)
+			}
+			krb5Conf := strings.TrimSuffix(dir, "/") + "/" + Krb5ConfKey
+			keytab := strings.TrimSuffix(dir, "/") + "/" + KeytabKey
+			if err := mountSecret(app, hdfs.KerberosSecret, dir, map[string]string{"KRB5_CONFIG": krb5Conf}); err != nil {
+				return err
+			}
+
+			conf := map[string]string{
+				"spark.kubernetes.kerberos.krb5.path": krb5Conf,
+				"spark.kerberos.keytab":               keytab,
+			}
+			if hdfs.KerberosPrincipal != "" {
+				conf["spark.kerberos.principal"] = hdfs.KerberosPrincipal
+			}
+			for k, v := range conf {
+				if err := setDefault(app, v, "spec", "sparkConf", k); err != nil {
+					return err
+				}
+			}
+		}
+
+		if hdfs.HadoopUserName != "" {
+			for _, pod := range podSpecs {
+				if err := setDefault(app, hdfs.HadoopUserName, "spec", pod, "envVars", "HADOOP_USER_NAME"); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// envName turns a credential name into an environment variable prefix
+func envName(name string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+}
+
+// setDefault sets the string at fields of app unless it is set already
+func setDefault(app map[string]interface{}, value string, fields ...string) error {
+	_, found, err := unstructured.NestedFieldNoCopy(app, fields...)
+	if err != nil || found {
+		return err
+	}
+	return unstructured.SetNestedField(app, value, fields...)
+}
+
+func setSecretKeyRef(app map[string]interface{}, pod, env, secret, key string) error {
+	ref := map[string]interface{}{"name": secret, "key": key}
+	return unstructured.SetNestedMap(app, ref, "spec", pod, "envSecretKeyRefs", env)
+}
+
+// mountSecret mounts secret at path in the driver and the executor, with the
+// environment variables of env
+func mountSecret(app map[string]interface{}, secret, path string, env map[string]string) error {
+	for _, pod := range podSpecs {
+		secrets, _, err := unstructured.NestedSlice(app, "spec", pod, "secrets")
+		if err != nil {
+			return err
+		}
+
+		mounted := false
+		for _, s := range secrets {
+			if m, ok := s.(map[string]interface{}); ok && m["name"] == secret && m["path"] == path {
+				mounted = true
+			}
+		}
+		if !mounted {
+			secrets = append(secrets, map[string]interface{}{"name": secret, "path": path, "secretType": secretTypeGeneric})
+			if err := unstructured.SetNestedSlice(app, secrets, "spec", pod, "secrets"); err != nil {
+				return err
+			}
+		}
+
+		for k, v := range env {
+			if err := setDefault(app, v, "spec", pod, "envVars", k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package spark
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	openaiosv1alpha1 "github.com/allenhaozi/webhook/api/openaios/v1alpha1"
+)
+
+func TestInjectCredentials(t *testing.T) {
+	app := map[string]interface{}{"spec": map[string]interface{}{
+		"driver": map[string]interface{}{
+			"envVars": map[string]interface{}{"HADOOP_USER_NAME": "from-chart"},
+		},
+	}}
+	creds := &openaiosv1alpha1.Credentials{
+		S3: []openaiosv1alpha1.S3Credential{{
+			Name:         "s3-default",
+			Endpoint:     "http://minio:9000",
+			AccessKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "minio"}, Key: "accesskey"},
+			SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "minio"}, Key: "secretkey"},
+		}},
+		HDFS: []openaiosv1alpha1.HDFSCredential{{
+			Name:                      "hdfs-default",
+			HadoopConfigurationDir:    "/opt/spark/etc/hdfs-default",
+			HadoopConfigurationSecret: "hdfs-default-conf",
+			KerberosConfigurationDir:  "/opt/spark/etc/kerberos-default/",
+			KerberosSecret:            "hdfs-default-kerberos",
+			KerberosPrincipal:         "hcml-lite@EXAMPLE.COM",
+			HadoopUserName:            "hcml-lite",
+		}},
+	}
+
+	// injecting twice mounts each secret once
+	for i := 0; i < 2; i++ {
+		if err := InjectCredentials(app, creds); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, pod := range podSpecs {
+		ref, _, _ := unstructured.NestedMap(app, "spec", pod, "envSecretKeyRefs", "S3_DEFAULT_SECRET_KEY")
+		if !reflect.DeepEqual(ref, map[string]interface{}{"name": "minio", "key": "secretkey"}) {
+			t.Errorf("%s: unexpected secret key ref %v", pod, ref)
+		}
+		envVars, _, _ := unstructured.NestedStringMap(app, "spec", pod, "envVars")
+		if envVars["S3_DEFAULT_ENDPOINT"] != "http://minio:9000" || envVars["HADOOP_CONF_DIR"] != "/opt/spark/etc/hdfs-default" ||
+			envVars["KRB5_CONFIG"] != "/opt/spark/etc/kerberos-default/krb5.conf" {
+			t.Errorf("%s: unexpected env vars %v", pod, envVars)
+		}
+		secrets, _, _ := unstructured.NestedSlice(app, "spec", pod, "secrets")
+		if len(secrets) != 2 {
+			t.Errorf("%s: expected the hadoop and kerberos secrets to be mounted once, got %v", pod, secrets)
+		}
+	}
+
+	user, _, _ := unstructured.NestedString(app, "spec", "driver", "envVars", "HADOOP_USER_NAME")
+	if user != "from-chart" {
+		t.Errorf("expected the env var set by the chart to be kept, got %s", user)
+	}
+	keytab, _, _ := unstructured.NestedString(app, "spec", "sparkConf", "spark.kerberos.keytab")
+	if keytab != "/opt/spark/etc/kerberos-default/keytab" {
+		t.Errorf("unexpected keytab %s", keytab)
+	}
+
+	creds.HDFS[0].HadoopConfigurationDir = ""
+	if err := InjectCredentials(app, creds); err == nil {
+		t.Error("expected a secret without mount directory to be rejected")
+	}
+}
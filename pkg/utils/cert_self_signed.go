@@ -2,6 +2,9 @@ package utils
 
 import (
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	cryptorand "crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -9,6 +12,7 @@ import (
 	"encoding/pem"
 	"math"
 	"math/big"
+	"net"
 	"time"
 
 	"github.com/pkg/errors"
@@ -21,61 +25,273 @@ import (
 var (
 	rsaKeySize           = 2048
 	CertificateBlockType = "CERTIFICATE"
+	privateKeyBlockType  = "PRIVATE KEY"
 )
 
+// KeyAlgorithm is the algorithm of the generated private keys
+type KeyAlgorithm string
+
+const (
+	KeyAlgorithmRSA     KeyAlgorithm = "rsa"
+	KeyAlgorithmECDSA   KeyAlgorithm = "ecdsa"
+	KeyAlgorithmEd25519 KeyAlgorithm = "ed25519"
+)
+
+const (
+	DefaultCertValidity = 10 * 365 * 24 * time.Hour
+	DefaultCACommonName = "self-signed-k8s-cert"
+)
+
+// CertOptions shape the generated certificates, the zero value keeps the defaults:
+// RSA-2048 keys, the service DNS names only and certificates valid for 10 years
+type CertOptions struct {
+	// DNSNames are added to the DNS names of the service
+	DNSNames []string
+	// IPs are added as IP SANs, for a webhook reached by URL
+	IPs          []net.IP
+	KeyAlgorithm KeyAlgorithm
+	// Validity of the serving certificate
+	Validity time.Duration
+	// CAValidity of a newly created CA
+	CAValidity   time.Duration
+	CACommonName string
+}
+
+func (o CertOptions) validity() time.Duration {
+	if o.Validity > 0 {
+		return o.Validity
+	}
+	return DefaultCertValidity
+}
+
+func (o CertOptions) caValidity() time.Duration {
+	if o.CAValidity > 0 {
+		return o.CAValidity
+	}
+	return DefaultCertValidity
+}
+
+func (o CertOptions) caCommonName() string {
+	if o.CACommonName != "" {
+		return o.CACommonName
+	}
+	return DefaultCACommonName
+}
+
+// Validate checks the key algorithm is known
+func (o CertOptions) Validate() error {
+	switch o.KeyAlgorithm {
+	case "", KeyAlgorithmRSA, KeyAlgorithmECDSA, KeyAlgorithmEd25519:
+		return nil
+	}
+	return errors.Errorf("unknown key algorithm %q, must be one of %s, %s, %s",
+		o.KeyAlgorithm, KeyAlgorithmRSA, KeyAlgorithmECDSA, KeyAlgorithmEd25519)
+}
+
 // reference: https://github.com/kubernetes/kubernetes/blob/v1.21.1/test/e2e/apimachinery/certs.go.
-func GenerateCert(namespaceName, serviceName string) (*common.CertContext, error) {
-	signingKey, err := NewPrivateKey()
+func GenerateCert(namespaceName, serviceName string, opts CertOptions) (*common.CertContext, error) {
+	signingKey, err := NewPrivateKey(opts.KeyAlgorithm)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to create CA private key")
 	}
 
-	signingCert, err := cert.NewSelfSignedCACert(cert.Config{CommonName: "self-signed-k8s-cert"}, signingKey)
+	signingCert, err := NewSelfSignedCACert(opts.caCommonName(), signingKey, opts.caValidity())
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to create CA cert for Apiserver")
 	}
 
-	key, err := NewPrivateKey()
+	signingKeyPEM, err := MarshalPrivateKeyToPEM(signingKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to marshal signed key")
+	}
+
+	c, err := newServingCert(namespaceName, serviceName, signingCert, signingKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.SigningCert = EncodeCertPEM(signingCert)
+	c.SigningKey = signingKeyPEM
+	c.CABundle = c.SigningCert
+
+	return c, nil
+}
+
+// RenewCert issues a new serving certificate signed by an existing CA, the CA
+// bundle of the returned context holds just that CA
+func RenewCert(namespaceName, serviceName string, signingCertPEM, signingKeyPEM []byte, opts CertOptions) (*common.CertContext, error) {
+	signingCerts, err := cert.ParseCertsPEM(signingCertPEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse CA cert")
+	}
+
+	key, err := keyutil.ParsePrivateKeyPEM(signingKeyPEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse CA private key")
+	}
+	signingKey, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("CA private key of type %T can not sign", key)
+	}
+
+	c, err := newServingCert(namespaceName, serviceName, signingCerts[0], signingKey, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.SigningCert = EncodeCertPEM(signingCerts[0])
+	c.SigningKey = signingKeyPEM
+	c.CABundle = c.SigningCert
+
+	return c, nil
+}
+
+// ServiceDNSName is the name the apiserver uses to reach a webhook service
+func ServiceDNSName(namespaceName, serviceName string) string {
+	return serviceName + "." + namespaceName + ".svc"
+}
+
+// CertExpiry returns when the first certificate of a PEM bundle expires
+func CertExpiry(certPEM []byte) (time.Time, error) {
+	certs, err := cert.ParseCertsPEM(certPEM)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return certs[0].NotAfter, nil
+}
+
+// TrimExpiredCerts drops the certificates of a PEM bundle which expired by now
+func TrimExpiredCerts(bundlePEM []byte, now time.Time) ([]byte, error) {
+	certs, err := cert.ParseCertsPEM(bundlePEM)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := []byte{}
+	for _, c := range certs {
+		if c.NotAfter.After(now) {
+			trimmed = append(trimmed, EncodeCertPEM(c)...)
+		}
+	}
+	return trimmed, nil
+}
+
+// ServiceDNSNames are the names a service is reachable by from inside the
+// cluster, from the short form to the fully qualified one
+func ServiceDNSNames(namespaceName, serviceName string) []string {
+	return []string{
+		serviceName,
+		serviceName + "." + namespaceName,
+		ServiceDNSName(namespaceName, serviceName),
+		ServiceDNSName(namespaceName, serviceName) + ".cluster.local",
+	}
+}
+
+// CertCovers reports whether the first certificate of a PEM bundle is valid for
+// every DNS name and IP address
+func CertCovers(certPEM []byte, dnsNames []string, ips []net.IP) (bool, error) {
+	certs, err := cert.ParseCertsPEM(certPEM)
+	if err != nil {
+		return false, err
+	}
+	for _, name := range dnsNames {
+		if certs[0].VerifyHostname(name) != nil {
+			return false, nil
+		}
+	}
+	for _, ip := range ips {
+		if certs[0].VerifyHostname(ip.String()) != nil {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// CertKeyAlgorithm returns the key algorithm of the first certificate of a PEM bundle
+func CertKeyAlgorithm(certPEM []byte) (KeyAlgorithm, error) {
+	certs, err := cert.ParseCertsPEM(certPEM)
+	if err != nil {
+		return "", err
+	}
+	switch certs[0].PublicKeyAlgorithm {
+	case x509.RSA:
+		return KeyAlgorithmRSA, nil
+	case x509.ECDSA:
+		return KeyAlgorithmECDSA, nil
+	case x509.Ed25519:
+		return KeyAlgorithmEd25519, nil
+	}
+	return "", errors.Errorf("unsupported public key algorithm %s", certs[0].PublicKeyAlgorithm)
+}
+
+// newServingCert issues the serving certificate of a service, the signing fields are left empty
+func newServingCert(namespaceName, serviceName string, signingCert *x509.Certificate, signingKey crypto.Signer, opts CertOptions) (*common.CertContext, error) {
+	key, err := NewPrivateKey(opts.KeyAlgorithm)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to create private key")
 	}
 
 	signedCert, err := NewSignedCert(
 		&cert.Config{
-			CommonName: serviceName + "." + namespaceName + ".svc",
-			AltNames:   cert.AltNames{DNSNames: []string{serviceName + "." + namespaceName + ".svc"}},
-			Usages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+			CommonName: ServiceDNSName(namespaceName, serviceName),
+			AltNames: cert.AltNames{
+				DNSNames: append(ServiceDNSNames(namespaceName, serviceName), opts.DNSNames...),
+				IPs:      opts.IPs,
+			},
+			Usages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		},
 		key,
 		signingCert,
 		signingKey,
+		opts.validity(),
 	)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to create signed certificate")
 	}
 
-	keyPEM, err := keyutil.MarshalPrivateKeyToPEM(key)
+	keyPEM, err := MarshalPrivateKeyToPEM(key)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to marshal private key")
 	}
 
-	signingKeyPEM, err := keyutil.MarshalPrivateKeyToPEM(signingKey)
+	c := &common.CertContext{
+		Cert: EncodeCertPEM(signedCert),
+		Key:  keyPEM,
+	}
+
+	return c, nil
+}
+
+// NewSelfSignedCACert creates a CA certificate valid for validity
+func NewSelfSignedCACert(commonName string, key crypto.Signer, validity time.Duration) (*x509.Certificate, error) {
+	serial, err := cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64))
 	if err != nil {
-		return nil, errors.Wrap(err, "Failed to marshal signed key")
+		return nil, err
 	}
 
-	c := &common.CertContext{
-		Cert:        EncodeCertPEM(signedCert),
-		Key:         keyPEM,
-		SigningCert: EncodeCertPEM(signingCert),
-		SigningKey:  signingKeyPEM,
+	now := time.Now()
+	tmpl := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName: commonName,
+		},
+		DNSNames:              []string{commonName},
+		NotBefore:             now.UTC(),
+		NotAfter:              now.Add(validity).UTC(),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		tmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
 	}
 
-	return c, nil
+	certDERBytes, err := x509.CreateCertificate(cryptorand.Reader, &tmpl, &tmpl, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(certDERBytes)
 }
 
 // NewSignedCert creates a signed certificate using the given CA certificate and key
-func NewSignedCert(cfg *cert.Config, key crypto.Signer, caCert *x509.Certificate, caKey crypto.Signer) (*x509.Certificate, error) {
+func NewSignedCert(cfg *cert.Config, key crypto.Signer, caCert *x509.Certificate, caKey crypto.Signer, validity time.Duration) (*x509.Certificate, error) {
 	serial, err := cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64))
 	if err != nil {
 		return nil, err
@@ -96,10 +312,14 @@ func NewSignedCert(cfg *cert.Config, key crypto.Signer, caCert *x509.Certificate
 		IPAddresses:  cfg.AltNames.IPs,
 		SerialNumber: serial,
 		NotBefore:    caCert.NotBefore,
-		NotAfter:     time.Now().Add(time.Hour * 24 * 365 * 10).UTC(),
-		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		NotAfter:     time.Now().Add(validity).UTC(),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
 		ExtKeyUsage:  cfg.Usages,
 	}
+	// only RSA keys encipher the TLS key exchange
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		certTmpl.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
 	certDERBytes, err := x509.CreateCertificate(cryptorand.Reader, &certTmpl, caCert, key.Public(), caKey)
 	if err != nil {
 		return nil, err
@@ -107,9 +327,31 @@ func NewSignedCert(cfg *cert.Config, key crypto.Signer, caCert *x509.Certificate
 	return x509.ParseCertificate(certDERBytes)
 }
 
-// NewPrivateKey creates an RSA private key
-func NewPrivateKey() (*rsa.PrivateKey, error) {
-	return rsa.GenerateKey(cryptorand.Reader, rsaKeySize)
+// NewPrivateKey creates a private key of the algorithm, RSA when empty
+func NewPrivateKey(algorithm KeyAlgorithm) (crypto.Signer, error) {
+	switch algorithm {
+	case "", KeyAlgorithmRSA:
+		return rsa.GenerateKey(cryptorand.Reader, rsaKeySize)
+	case KeyAlgorithmECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	case KeyAlgorithmEd25519:
+		_, key, err := ed25519.GenerateKey(cryptorand.Reader)
+		return key, err
+	}
+	return nil, errors.Errorf("unknown key algorithm %q", algorithm)
+}
+
+// MarshalPrivateKeyToPEM encodes RSA and ECDSA keys the way keyutil does and
+// Ed25519 keys, which keyutil doesn't support, as PKCS #8
+func MarshalPrivateKeyToPEM(key crypto.Signer) ([]byte, error) {
+	if _, ok := key.(ed25519.PrivateKey); !ok {
+		return keyutil.MarshalPrivateKeyToPEM(key)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: privateKeyBlockType, Bytes: der}), nil
 }
 
 // EncodeCertPEM returns PEM-endcoded certificate data
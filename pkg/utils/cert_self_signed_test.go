@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGenerateCertOptions(t *testing.T) {
+	for _, algorithm := range []KeyAlgorithm{KeyAlgorithmRSA, KeyAlgorithmECDSA, KeyAlgorithmEd25519} {
+		opts := CertOptions{
+			DNSNames:     []string{"webhook.example.com"},
+			IPs:          []net.IP{net.ParseIP("10.0.0.1")},
+			KeyAlgorithm: algorithm,
+			Validity:     24 * time.Hour,
+			CAValidity:   48 * time.Hour,
+		}
+		c, err := GenerateCert("default", "webhook-service", opts)
+		if err != nil {
+			t.Fatalf("%s: %v", algorithm, err)
+		}
+
+		// the pair must load the way the webhook server loads it
+		if _, err := tls.X509KeyPair(c.Cert, c.Key); err != nil {
+			t.Errorf("%s: %v", algorithm, err)
+		}
+		if got, _ := CertKeyAlgorithm(c.Cert); got != algorithm {
+			t.Errorf("%s: unexpected key algorithm %s", algorithm, got)
+		}
+
+		names := append(ServiceDNSNames("default", "webhook-service"), opts.DNSNames...)
+		if covered, err := CertCovers(c.Cert, names, opts.IPs); err != nil || !covered {
+			t.Errorf("%s: certificate doesn't cover %v %v", algorithm, names, opts.IPs)
+		}
+
+		notAfter, _ := CertExpiry(c.Cert)
+		if d := time.Until(notAfter); d > 24*time.Hour || d < 23*time.Hour {
+			t.Errorf("%s: unexpected certificate validity %v", algorithm, d)
+		}
+		caNotAfter, _ := CertExpiry(c.SigningCert)
+		if d := time.Until(caNotAfter); d > 48*time.Hour || d < 47*time.Hour {
+			t.Errorf("%s: unexpected CA validity %v", algorithm, d)
+		}
+
+		// the CA key round-trips through PEM
+		if _, err := RenewCert("default", "webhook-service", c.SigningCert, c.SigningKey, opts); err != nil {
+			t.Errorf("%s: renew: %v", algorithm, err)
+		}
+	}
+}
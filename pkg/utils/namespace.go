@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/allenhaozi/webhook/api/common"
+)
+
+// ServiceAccountNamespaceFile holds the namespace of a pod, it is mounted along its service account token
+var ServiceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// DiscoverNamespace returns the namespace the webhook runs in: ns when not
+// empty, then the MY_POD_NAMESPACE environment variable, then the service
+// account namespace file in a pod, then the namespace of the current kubeconfig
+// context out of the cluster
+func DiscoverNamespace(ns string) (string, error) {
+	if ns != "" {
+		return ns, nil
+	}
+
+	if v := os.Getenv(common.MyPodNamespace); v != "" {
+		return v, nil
+	}
+
+	if data, err := os.ReadFile(ServiceAccountNamespaceFile); err == nil {
+		if v := strings.TrimSpace(string(data)); v != "" {
+			return v, nil
+		}
+	}
+
+	v, _, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{}).Namespace()
+	if err != nil {
+		return "", errors.Wrap(err, "read namespace of the kubeconfig context failure")
+	}
+	return v, nil
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/allenhaozi/webhook/api/common"
+)
+
+func TestDiscoverNamespace(t *testing.T) {
+	namespaceFile := filepath.Join(t.TempDir(), "namespace")
+	if err := os.WriteFile(namespaceFile, []byte("from-file\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(v string) { ServiceAccountNamespaceFile = v }(ServiceAccountNamespaceFile)
+	ServiceAccountNamespaceFile = namespaceFile
+
+	t.Setenv(common.MyPodNamespace, "")
+	if ns, err := DiscoverNamespace(""); err != nil || ns != "from-file" {
+		t.Errorf("expected the service account namespace, got %q %v", ns, err)
+	}
+
+	t.Setenv(common.MyPodNamespace, "from-env")
+	if ns, err := DiscoverNamespace(""); err != nil || ns != "from-env" {
+		t.Errorf("expected the environment namespace, got %q %v", ns, err)
+	}
+
+	if ns, err := DiscoverNamespace("from-flag"); err != nil || ns != "from-flag" {
+		t.Errorf("expected the flag namespace, got %q %v", ns, err)
+	}
+}